@@ -0,0 +1,457 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+)
+
+/*
+ * This module implements the v2 api.
+ * Every v2 response is an envelope with the data, an error (with a code and a message) and the snapshot timestamp.
+ * HTTP status codes are derived from the error code, and can be configured with status_codes.
+ */
+
+// v2Error describes why a v2 request did not succeed
+type v2Error struct {
+	Code    string `json:"code" yaml:"code"`
+	Message string `json:"message" yaml:"message"`
+}
+
+// v2Response is the envelope of every v2 response
+type v2Response struct {
+	Data      any       `json:"data" yaml:"data"`
+	Error     *v2Error  `json:"error,omitempty" yaml:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp" yaml:"timestamp"`
+}
+
+// v2Request holds the snapshot timestamp of a v2 request, which is taken before any node is queried
+type v2Request struct {
+	c        *gin.Context
+	snapshot time.Time
+}
+
+func newV2Request(c *gin.Context) v2Request {
+	return v2Request{c: c, snapshot: time.Now().UTC()}
+}
+
+// ok responds with data
+func (v2r v2Request) ok(data any) {
+	render(v2r.c, globalHandler.config.StatusCodes.StatusCode(outcomeOk), v2Response{
+		Data:      data,
+		Timestamp: v2r.snapshot,
+	})
+}
+
+// fail responds with an error code and message, and optionally with data
+func (v2r v2Request) fail(code string, message string, data any) {
+	render(v2r.c, globalHandler.config.StatusCodes.StatusCode(code), v2Response{
+		Data:      data,
+		Error:     &v2Error{Code: code, Message: message},
+		Timestamp: v2r.snapshot,
+	})
+}
+
+// group returns the group query parameter, which defaults to all.
+// When the group is not defined, an unknown_group error is sent, and ok is false.
+func (v2r v2Request) group() (group string, ok bool) {
+	group = v2r.c.DefaultQuery("group", "all")
+	if globalHandler.config.HasGroup(group) {
+		return group, true
+	}
+
+	v2r.fail(outcomeUnknownGroup, "group "+group+" is not defined", nil)
+
+	return "", false
+}
+
+// formatNodes formats nodes as requested with the format query parameter
+func (v2r v2Request) formatNodes(names []string) ([]string, bool) {
+	format := v2r.c.DefaultQuery("format", nodeFormatName)
+	formatted := []string{}
+
+	for _, name := range names {
+		node, err := globalHandler.FormatNode(name, format)
+		if err != nil {
+			v2r.fail(outcomeInvalidRequest, err.Error(), nil)
+
+			return nil, false
+		}
+
+		formatted = append(formatted, node)
+	}
+
+	return formatted, true
+}
+
+func (v2r v2Request) okNodes(names []string) {
+	if formatted, ok := v2r.formatNodes(names); ok {
+		v2r.ok(formatted)
+	}
+}
+
+func (v2r v2Request) okNode(name string) {
+	if formatted, ok := v2r.formatNodes([]string{name}); ok {
+		v2r.ok(formatted[0])
+	}
+}
+
+func getV2Primary(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	group, ok := v2r.group()
+	if !ok {
+		return
+	}
+
+	primaries := globalHandler.GetPrimaries(group)
+	if len(primaries) == 1 {
+		primaries = nodeFilter(c).Apply(globalHandler.config.Hosts, primaries)
+	}
+
+	switch len(primaries) {
+	case 0:
+		v2r.fail(outcomeNotFound, "no primary available", nil)
+	case 1:
+		if err := requireSync(c, group); err != nil {
+			v2r.fail(outcomeSyncUnsatisfied, err.Error(), nil)
+		} else {
+			v2r.okNode(primaries[0])
+		}
+	default:
+		if formatted, ok := v2r.formatNodes(primaries); ok {
+			v2r.fail(outcomeSplitBrain, "multiple primaries available", formatted)
+		}
+	}
+}
+
+func getV2SyncStandbys(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	group, ok := v2r.group()
+	if !ok {
+		return
+	}
+
+	state, err := globalHandler.GetSyncState(group)
+
+	switch {
+	case errors.Is(err, errNoPrimary):
+		v2r.fail(outcomeNotFound, err.Error(), nil)
+	case errors.Is(err, errMultiplePrimaries):
+		v2r.fail(outcomeSplitBrain, err.Error(), nil)
+	case err != nil:
+		v2r.fail(outcomeCheckFailed, err.Error(), nil)
+	default:
+		v2r.okNodes(state.Standbys)
+	}
+}
+
+func getV2Primaries(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	group, ok := v2r.group()
+	if !ok {
+		return
+	}
+
+	v2r.okNodes(nodeFilter(c).Apply(globalHandler.config.Hosts, globalHandler.GetPrimaries(group)))
+}
+
+func getV2Standbys(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	group, ok := v2r.group()
+	if !ok {
+		return
+	}
+
+	v2r.okNodes(nodeFilter(c).Apply(globalHandler.config.Hosts, globalHandler.GetStandbys(group)))
+}
+
+func getV2Standby(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	group, ok := v2r.group()
+	if !ok {
+		return
+	}
+
+	standby, err := globalHandler.SelectStandby(group,
+		c.DefaultQuery("strategy", strategyRoundRobin), nodeFilter(c), c.Query("fallback") == ghStatusPrimary)
+	if err != nil {
+		v2r.fail(outcomeInvalidRequest, err.Error(), nil)
+	} else if standby == "" {
+		v2r.fail(outcomeNotFound, "no standby available", nil)
+	} else {
+		v2r.okNode(standby)
+	}
+}
+
+func getV2Nodes(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	group, ok := v2r.group()
+	if !ok {
+		return
+	}
+
+	v2r.ok(globalHandler.GetNodes(group, nodeFilter(c)))
+}
+
+func getV2Target(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	group, ok := v2r.group()
+	if !ok {
+		return
+	}
+
+	attrs, err := pg.NewTargetSessionAttrs(c.DefaultQuery("attrs", string(pg.TargetAny)))
+	if err != nil {
+		v2r.fail(outcomeInvalidRequest, err.Error(), nil)
+
+		return
+	}
+
+	v2r.okNodes(globalHandler.GetTargets(group, attrs))
+}
+
+func getV2ConnString(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	group, ok := v2r.group()
+	if !ok {
+		return
+	}
+
+	attrs, err := pg.NewTargetSessionAttrs(c.DefaultQuery("attrs", string(pg.TargetReadWrite)))
+	if err != nil {
+		v2r.fail(outcomeInvalidRequest, err.Error(), nil)
+
+		return
+	}
+
+	connString, err := globalHandler.GetConnString(group, attrs,
+		c.DefaultQuery("format", formatLibpq))
+	if err != nil {
+		v2r.fail(outcomeInvalidRequest, err.Error(), nil)
+	} else {
+		v2r.ok(connString)
+	}
+}
+
+func getV2Groups(c *gin.Context) {
+	newV2Request(c).ok(globalHandler.GetGroups())
+}
+
+func getV2Group(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	summary, err := globalHandler.GetGroup(c.Param("name"))
+	if err != nil {
+		v2r.fail(outcomeUnknownGroup, err.Error(), nil)
+	} else {
+		v2r.ok(summary)
+	}
+}
+
+func getV2Tree(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	tree, err := globalHandler.GetTree(c.Param("name"))
+	if err != nil {
+		v2r.fail(outcomeUnknownGroup, err.Error(), nil)
+	} else {
+		v2r.ok(tree)
+	}
+}
+
+func getV2Status(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	switch status := globalHandler.GetNodeStatus(c.Param("id")); status {
+	case ghStatusInvalid:
+		v2r.fail(outcomeInvalidNode, "node "+c.Param("id")+" is not defined", nil)
+	case ghStatusUnavailable:
+		v2r.fail(outcomeUnavailable, "node "+c.Param("id")+" is not available", status)
+	default:
+		v2r.ok(status)
+	}
+}
+
+func getV2Availability(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	limit, err := availabilityLimit(c)
+	if err != nil {
+		v2r.fail(outcomeInvalidRequest, err.Error(), nil)
+
+		return
+	}
+
+	err = globalHandler.CheckNodeAvailability(c.Param("id"), limit)
+
+	switch outcome := availabilityOutcome(err); outcome {
+	case outcomeOk:
+		v2r.ok(ghStatusOk)
+	case outcomeInvalidNode:
+		v2r.fail(outcome, "node "+c.Param("id")+" is not defined", nil)
+	default:
+		v2r.fail(outcome, err.Error(), nil)
+	}
+}
+
+func getV2GroupAvailability(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	limit, err := availabilityLimit(c)
+	if err != nil {
+		v2r.fail(outcomeInvalidRequest, err.Error(), nil)
+
+		return
+	}
+
+	availability, err := globalHandler.GetGroupAvailability(c.Param("name"), limit)
+
+	switch {
+	case err != nil:
+		v2r.fail(outcomeUnknownGroup, err.Error(), nil)
+	case availability.Available < availability.Total:
+		v2r.fail(outcomeUnavailable, fmt.Sprintf("%d of %d members are available", availability.Available,
+			availability.Total), availability)
+	default:
+		v2r.ok(availability)
+	}
+}
+
+func getV2GroupStats(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	stats, err := globalHandler.GetGroupStats(c.Param("name"))
+	if err != nil {
+		v2r.fail(outcomeUnknownGroup, err.Error(), nil)
+	} else {
+		v2r.ok(stats)
+	}
+}
+
+func getV2Candidates(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	candidates, err := globalHandler.GetCandidates(c.Param("name"))
+	if err != nil {
+		v2r.fail(outcomeUnknownGroup, err.Error(), nil)
+	} else {
+		v2r.ok(candidates)
+	}
+}
+
+func postV2Switchover(c *gin.Context) {
+	v2r := newV2Request(c)
+
+	group := c.Param("name")
+	if !globalHandler.config.HasGroup(group) {
+		v2r.fail(outcomeUnknownGroup, "group "+group+" is not defined", nil)
+
+		return
+	}
+
+	var request switchoverRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		v2r.fail(outcomeInvalidRequest, "invalid request body: "+err.Error(), nil)
+
+		return
+	}
+
+	report, err := globalHandler.Switchover(group, request.Target, request.DryRun)
+
+	switch {
+	case err == nil:
+		v2r.ok(report)
+	case errors.Is(err, errSwitchoverRejected):
+		v2r.fail(outcomeSwitchoverRejected, err.Error(), report)
+	default:
+		v2r.fail(outcomeSwitchoverFailed, err.Error(), report)
+	}
+}
+
+func setV2Maintenance(c *gin.Context, maintenance bool) {
+	v2r := newV2Request(c)
+
+	if err := globalHandler.SetMaintenance(c.Param("name"), maintenance); err != nil {
+		v2r.fail(outcomeUnknownGroup, err.Error(), nil)
+	} else {
+		v2r.ok(maintenance)
+	}
+}
+
+func postV2Maintenance(c *gin.Context) {
+	setV2Maintenance(c, true)
+}
+
+func deleteV2Maintenance(c *gin.Context) {
+	setV2Maintenance(c, false)
+}
+
+// v2Routes returns all routes of the v2 api
+func v2Routes() []apiRoute {
+	nodeParams := append([]apiParam{paramGroup(), paramNodeFormat()}, paramsNodeFilter()...)
+
+	return []apiRoute{
+		{Method: http.MethodGet, Path: "/v2/primary", Handler: getV2Primary, Summary: "the primary of a group",
+			Params: append([]apiParam{paramRequireSync()}, nodeParams...), V2: true},
+		{Method: http.MethodGet, Path: "/v2/sync_standbys", Handler: getV2SyncStandbys,
+			Summary: "all connected synchronous standbys of the primary of a group",
+			Params:  []apiParam{paramGroup(), paramNodeFormat()}, Schema: schemaStrings(), V2: true},
+		{Method: http.MethodGet, Path: "/v2/primaries", Handler: getV2Primaries, Summary: "all primaries of a group",
+			Params: nodeParams, Schema: schemaStrings(), V2: true},
+		{Method: http.MethodGet, Path: "/v2/standbys", Handler: getV2Standbys, Summary: "all standbys of a group",
+			Params: nodeParams, Schema: schemaStrings(), V2: true},
+		{Method: http.MethodGet, Path: "/v2/standby", Handler: getV2Standby, Summary: "one standby of a group",
+			Params: append([]apiParam{paramStrategy(), paramFallback()}, nodeParams...), V2: true},
+		{Method: http.MethodGet, Path: "/v2/nodes", Handler: getV2Nodes, Summary: "inventory of all nodes of a group",
+			Params: append([]apiParam{paramGroup()}, paramsNodeFilter()...), Schema: schemaNodes(), V2: true},
+		{Method: http.MethodGet, Path: "/v2/target", Handler: getV2Target,
+			Summary: "all nodes of a group that match target_session_attrs",
+			Params:  []apiParam{paramGroup(), paramAttrs(pg.TargetAny), paramNodeFormat()}, Schema: schemaStrings(),
+			V2: true},
+		{Method: http.MethodGet, Path: "/v2/connstring", Handler: getV2ConnString,
+			Summary: "multi-host connection string for all nodes of a group",
+			Params:  []apiParam{paramGroup(), paramAttrs(pg.TargetReadWrite), paramConnStringFormat()}, V2: true},
+		{Method: http.MethodGet, Path: "/v2/groups", Handler: getV2Groups, Summary: "names of all groups",
+			Schema: schemaStrings(), V2: true},
+		{Method: http.MethodGet, Path: "/v2/groups/:name", Handler: getV2Group,
+			Summary: "members, roles and health of a group", Params: []apiParam{paramGroupName()},
+			Schema: schemaGroup(), V2: true},
+		{Method: http.MethodGet, Path: "/v2/groups/:name/tree", Handler: getV2Tree,
+			Summary: "replication tree of a group, including cascading standbys", Params: []apiParam{paramGroupName()},
+			Schema: schemaTree(), V2: true},
+		{Method: http.MethodGet, Path: "/v2/groups/:name/availability", Handler: getV2GroupAvailability,
+			Summary: "availability of all members of a group", Params: []apiParam{paramGroupName(), paramLimit()},
+			Schema: schemaGroupAvailability(), V2: true},
+		{Method: http.MethodGet, Path: "/v2/groups/:name/stats", Handler: getV2GroupStats,
+			Summary: "availability statistics of a group over the last 1h, 24h and 7d",
+			Params:  []apiParam{paramGroupName()}, Schema: schemaGroupStats(), V2: true},
+		{Method: http.MethodGet, Path: "/v2/groups/:name/candidates", Handler: getV2Candidates,
+			Summary: "standbys of a group, ranked as candidates for promotion", Params: []apiParam{paramGroupName()},
+			Schema: schemaCandidates(), V2: true},
+		{Method: http.MethodPost, Path: "/v2/groups/:name/switchover", Handler: postV2Switchover,
+			Summary: "switch the primary of a group over to a standby", Params: []apiParam{paramGroupName()},
+			Body: schemaSwitchoverRequest(), Schema: schemaSwitchover(), Admin: true, V2: true},
+		{Method: http.MethodPost, Path: "/v2/groups/:name/maintenance", Handler: postV2Maintenance,
+			Summary: "put a group in maintenance, which prevents automatic failover",
+			Params:  []apiParam{paramGroupName()}, Schema: schemaBoolean(), Admin: true, V2: true},
+		{Method: http.MethodDelete, Path: "/v2/groups/:name/maintenance", Handler: deleteV2Maintenance,
+			Summary: "take a group out of maintenance", Params: []apiParam{paramGroupName()}, Schema: schemaBoolean(),
+			Admin: true, V2: true},
+		{Method: http.MethodGet, Path: "/v2/nodes/:id/status", Handler: getV2Status, Summary: "status of a node",
+			Params: []apiParam{paramNodeID()}, V2: true},
+		{Method: http.MethodGet, Path: "/v2/nodes/:id/availability", Handler: getV2Availability,
+			Summary: "availability of a node", Params: []apiParam{paramNodeID(), paramLimit()}, V2: true},
+	}
+}
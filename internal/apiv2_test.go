@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Apiv2", func() {
+	gin.SetMode(gin.TestMode)
+
+	request := func(configure func(v2r v2Request)) (*httptest.ResponseRecorder, map[string]any) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodGet, "/v2/primary", nil)
+		configure(newV2Request(c))
+
+		envelope := map[string]any{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &envelope)).To(Succeed())
+
+		return recorder, envelope
+	}
+	BeforeEach(func() {
+		newTestHandler(RouteConfig{StatusCodes: RouteStatusCodes{outcomeSplitBrain: http.StatusServiceUnavailable}})
+	})
+	Context("envelope", func() {
+		It("should hold data and the snapshot timestamp", func() {
+			recorder, envelope := request(func(v2r v2Request) { v2r.ok("host1") })
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(envelope).To(HaveKeyWithValue("data", "host1"))
+			Expect(envelope).To(HaveKey("timestamp"))
+			Expect(envelope).NotTo(HaveKey("error"))
+		})
+		It("should hold the error, with the status code for the error code", func() {
+			recorder, envelope := request(func(v2r v2Request) {
+				v2r.fail(outcomeNotFound, "no primary available", nil)
+			})
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+			Expect(envelope).To(HaveKeyWithValue("error", map[string]any{
+				"code": outcomeNotFound, "message": "no primary available",
+			}))
+		})
+		It("should use configured status codes", func() {
+			recorder, _ := request(func(v2r v2Request) {
+				v2r.fail(outcomeSplitBrain, "multiple primaries available", []string{"host1", "host2"})
+			})
+			Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+})
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+ * This module guards the routes that change the cluster (like switchover) with a bearer token.
+ * Without admin_token in config, these routes are disabled.
+ */
+
+const bearerPrefix = "Bearer "
+
+// authorized returns the status code and message to abort with, or 0 when the request holds the admin token
+func authorized(header string, token string) (int, string) {
+	if token == "" {
+		return http.StatusForbidden, "admin api is disabled (admin_token is not configured)"
+	}
+
+	if !strings.HasPrefix(header, bearerPrefix) ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, bearerPrefix)), []byte(token)) != 1 {
+		return http.StatusUnauthorized, "invalid or missing bearer token"
+	}
+
+	return 0, ""
+}
+
+// requireAdmin returns middleware that aborts requests without the admin token, with the v2 envelope for v2 routes
+func requireAdmin(v2 bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status, message := authorized(c.GetHeader("Authorization"), globalHandler.config.AdminToken)
+		if status == 0 {
+			c.Next()
+
+			return
+		}
+
+		if !v2 {
+			render(c, status, message)
+		} else if status == http.StatusForbidden {
+			newV2Request(c).fail(outcomeForbidden, message, nil)
+		} else {
+			newV2Request(c).fail(outcomeUnauthorized, message, nil)
+		}
+
+		c.Abort()
+	}
+}
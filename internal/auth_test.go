@@ -0,0 +1,27 @@
+package internal
+
+import (
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Auth", func() {
+	Context("admin token", func() {
+		It("should disable the admin api without token", func() {
+			status, _ := authorized("Bearer secret", "")
+			Expect(status).To(Equal(http.StatusForbidden))
+		})
+		It("should reject a missing or invalid token", func() {
+			for _, header := range []string{"", "secret", "Bearer other", "Basic secret"} {
+				status, _ := authorized(header, "secret")
+				Expect(status).To(Equal(http.StatusUnauthorized), header)
+			}
+		})
+		It("should accept the token", func() {
+			status, _ := authorized("Bearer secret", "secret")
+			Expect(status).To(BeZero())
+		})
+	})
+})
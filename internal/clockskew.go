@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+ * This module compares the clock of every node with the clock of pgroute66, corrected for the round trip time.
+ * The heartbeat is written with the clock of the primary and compared with the clock of a standby,
+ * so the difference in skew between both shows up as (negative) lag, unless availability results are corrected.
+ * Clock skew is measured in the background at a fixed interval, so that availability checks only read the results.
+ */
+
+// routeClockSkews holds the last measured clock skew of every node
+type routeClockSkews struct {
+	mutex sync.RWMutex
+	skews map[string]time.Duration
+}
+
+func newRouteClockSkews() *routeClockSkews {
+	return &routeClockSkews{skews: map[string]time.Duration{}}
+}
+
+func (rcs *routeClockSkews) get(name string) (skew time.Duration, measured bool) {
+	rcs.mutex.RLock()
+	defer rcs.mutex.RUnlock()
+
+	skew, measured = rcs.skews[name]
+
+	return skew, measured
+}
+
+func (rcs *routeClockSkews) set(name string, skew time.Duration) {
+	rcs.mutex.Lock()
+	defer rcs.mutex.Unlock()
+
+	rcs.skews[name] = skew
+}
+
+func (rcs *routeClockSkews) remove(name string) {
+	rcs.mutex.Lock()
+	defer rcs.mutex.Unlock()
+
+	delete(rcs.skews, name)
+}
+
+// NodeClockSkew returns how far the clock of a node is ahead of the clock of pgroute66,
+// and logs a warning when that exceeds the threshold
+func (prh PgRouteHandler) NodeClockSkew(name string) (time.Duration, error) {
+	conn, exists := prh.connections[name]
+	if !exists {
+		return 0, errUndefinedNode
+	}
+
+	skew, roundTrip, err := conn.ClockSkew(context.Background())
+	if err != nil {
+		return 0, err
+	}
+
+	if skew.Abs() > prh.config.ClockSkew.WarnThreshold() {
+		prh.log.Warnf("clock of node %s is %s ahead of pgroute66 (measured with a round trip of %s)", name, skew,
+			roundTrip)
+	}
+
+	return skew, nil
+}
+
+// measureClockSkews measures the clock skew of all nodes, and forgets the skew of nodes that cannot be measured
+func (prh PgRouteHandler) measureClockSkews() {
+	for name := range prh.connections {
+		skew, err := prh.NodeClockSkew(name)
+		if err != nil {
+			prh.log.Debugf("Could not get clock skew of node %s, %s", name, err.Error())
+			prh.clockSkews.remove(name)
+
+			continue
+		}
+
+		prh.clockSkews.set(name, skew)
+	}
+}
+
+// clockSkewLoop measures the clock skew of all nodes at every interval
+func (prh PgRouteHandler) clockSkewLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	prh.measureClockSkews()
+
+	for range ticker.C {
+		prh.measureClockSkews()
+	}
+}
+
+// RunClockSkew starts measuring the clock skew of all nodes in the background
+func (prh *PgRouteHandler) RunClockSkew() {
+	interval := prh.config.ClockSkew.MeasureInterval()
+	prh.log.Infof("Measuring clock skew every %s", interval)
+
+	go prh.clockSkewLoop(interval)
+}
+
+// GetClockSkews returns the last measured clock skew (in seconds) of all members of a group
+func (prh PgRouteHandler) GetClockSkews(group string) map[string]float64 {
+	skews := map[string]float64{}
+
+	for _, name := range prh.config.GroupHosts(group) {
+		if skew, measured := prh.clockSkews.get(name); measured {
+			skews[name] = skew.Seconds()
+		}
+	}
+
+	return skews
+}
+
+// availabilityCorrection returns the difference in clock skew between a node and the primary of its (first) group,
+// which is the false lag that clock skew adds to the availability of the node.
+// Without correct in the clock_skew config, or when the skew was not measured, there is no correction.
+func (prh PgRouteHandler) availabilityCorrection(name string) time.Duration {
+	if !prh.config.ClockSkew.Correct {
+		return 0
+	}
+
+	primary, err := prh.nodePrimary(name)
+	if err != nil || primary == name {
+		return 0
+	}
+
+	nodeSkew, measured := prh.clockSkews.get(name)
+	if !measured {
+		prh.log.Debugf("No clock skew measured for node %s", name)
+
+		return 0
+	}
+
+	primarySkew, measured := prh.clockSkews.get(primary)
+	if !measured {
+		prh.log.Debugf("No clock skew measured for primary %s", primary)
+
+		return 0
+	}
+
+	return nodeSkew - primarySkew
+}
@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+)
+
+/*
+ * This module builds multi-host connection strings, so that libpq, pgx and JDBC clients can fail over by themselves.
+ */
+
+const (
+	formatLibpq      = "libpq"
+	formatURI        = "uri"
+	formatJDBC       = "jdbc"
+	paramHost        = "host"
+	paramPort        = "port"
+	paramUser        = "user"
+	paramDBName      = "dbname"
+	paramTargetAttrs = "target_session_attrs"
+)
+
+// connStringNode is a host and port to be listed in a multi-host connection string
+type connStringNode struct {
+	Host string
+	Port string
+}
+
+// connStringSpec holds everything that is required to build a multi-host connection string
+type connStringSpec struct {
+	Nodes  []connStringNode
+	Params pg.Dsn
+	Attrs  pg.TargetSessionAttrs
+}
+
+// jdbcParam maps libpq parameters to their pgjdbc equivalent. Parameters without equivalent are left out.
+func jdbcParam(libpqParam string) (string, bool) {
+	switch libpqParam {
+	case paramUser, "sslmode", "sslrootcert", "sslcert", "sslkey", "options":
+		return libpqParam, true
+	case "application_name":
+		return "ApplicationName", true
+	case "connect_timeout":
+		return "connectTimeout", true
+	}
+
+	return "", false
+}
+
+// jdbcTargetServerType returns the pgjdbc equivalent of target_session_attrs
+func jdbcTargetServerType(attrs pg.TargetSessionAttrs) string {
+	switch attrs {
+	case pg.TargetPreferStandby:
+		return "preferSecondary"
+	case pg.TargetReadOnly, pg.TargetStandby:
+		return "secondary"
+	case pg.TargetReadWrite, pg.TargetPrimary:
+		return "primary"
+	default:
+		return "any"
+	}
+}
+
+// isClientParam returns false for parameters that should not be shared across hosts in a connection string
+func isClientParam(key string) bool {
+	switch key {
+	case paramHost, paramPort, "hostaddr", paramTargetAttrs:
+		return false
+	}
+
+	// pool_* parameters are pgx pool specific, and not supported by other clients
+	return !strings.HasPrefix(key, "pool_")
+}
+
+// commonParams returns the client parameters that have the same value for all DSN's
+func commonParams(dsns []pg.Dsn) pg.Dsn {
+	common := pg.Dsn{}
+	if len(dsns) == 0 {
+		return common
+	}
+
+	for key, value := range dsns[0] {
+		if !isClientParam(key) {
+			continue
+		}
+
+		same := true
+		for _, dsn := range dsns[1:] {
+			if other, exists := dsn[key]; !exists || other != value {
+				same = false
+
+				break
+			}
+		}
+
+		if same {
+			common[key] = value
+		}
+	}
+
+	return common
+}
+
+func (css connStringSpec) hostPorts() []string {
+	hostPorts := make([]string, 0, len(css.Nodes))
+	for _, node := range css.Nodes {
+		hostPorts = append(hostPorts, net.JoinHostPort(node.Host, node.Port))
+	}
+
+	return hostPorts
+}
+
+func (css connStringSpec) libpq() string {
+	dsn := pg.Dsn{paramTargetAttrs: string(css.Attrs)}
+
+	var hosts, ports []string
+	for _, node := range css.Nodes {
+		hosts = append(hosts, node.Host)
+		ports = append(ports, node.Port)
+	}
+
+	dsn[paramHost] = strings.Join(hosts, ",")
+	dsn[paramPort] = strings.Join(ports, ",")
+
+	for key, value := range css.Params {
+		dsn[key] = value
+	}
+
+	return dsn.String()
+}
+
+func (css connStringSpec) uri() string {
+	query := url.Values{paramTargetAttrs: []string{string(css.Attrs)}}
+
+	for key, value := range css.Params {
+		if key != paramUser && key != paramDBName {
+			query.Set(key, value)
+		}
+	}
+
+	uri := url.URL{
+		Scheme:   "postgresql",
+		Host:     strings.Join(css.hostPorts(), ","),
+		Path:     "/" + css.Params[paramDBName],
+		RawQuery: query.Encode(),
+	}
+
+	if user, exists := css.Params[paramUser]; exists {
+		uri.User = url.User(user)
+	}
+
+	return uri.String()
+}
+
+func (css connStringSpec) jdbc() string {
+	query := url.Values{"targetServerType": []string{jdbcTargetServerType(css.Attrs)}}
+
+	for key, value := range css.Params {
+		if jdbcKey, supported := jdbcParam(key); supported {
+			query.Set(jdbcKey, value)
+		}
+	}
+
+	return fmt.Sprintf("jdbc:postgresql://%s/%s?%s", strings.Join(css.hostPorts(), ","),
+		url.PathEscape(css.Params[paramDBName]), query.Encode())
+}
+
+// Format returns the connection string in a specific format (libpq, uri, or jdbc)
+func (css connStringSpec) Format(format string) (string, error) {
+	switch format {
+	case formatLibpq:
+		return css.libpq(), nil
+	case formatURI:
+		return css.uri(), nil
+	case formatJDBC:
+		return css.jdbc(), nil
+	}
+
+	return "", fmt.Errorf("invalid format %s (should be one of %s, %s, or %s)", format, formatLibpq, formatURI,
+		formatJDBC)
+}
+
+// GetConnString returns a multi-host connection string for all nodes of a group.
+// Nodes are ordered by role, so that clients try the nodes most likely to satisfy attrs first.
+func (prh PgRouteHandler) GetConnString(group string, attrs pg.TargetSessionAttrs, format string) (string, error) {
+	// read-write clients should try writable nodes first, and other clients standbys
+	first, second := prh.GetTargets(group, pg.TargetReadWrite), prh.GetStandbys(group)
+	switch attrs {
+	case pg.TargetReadWrite, pg.TargetPrimary:
+		// writable nodes first
+	case pg.TargetAny:
+		first, second = nil, nil
+	default:
+		first, second = second, first
+	}
+
+	// nodes that diverged from the timeline of the primary are left out
+	rebuild := prh.needsRebuild(group)
+
+	var names []string
+	for name := range prh.connections.FilteredConnections(prh.config.GroupHosts(group)) {
+		if !slices.Contains(rebuild, name) {
+			names = append(names, name)
+		}
+	}
+
+	rank := func(name string) int {
+		switch {
+		case slices.Contains(first, name):
+			return 0
+		case slices.Contains(second, name):
+			return 1
+		default:
+			// unavailable nodes go last
+			return 2
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if rank(names[i]) != rank(names[j]) {
+			return rank(names[i]) < rank(names[j])
+		}
+
+		return names[i] < names[j]
+	})
+
+	spec := connStringSpec{Attrs: attrs}
+
+	dsns := make([]pg.Dsn, 0, len(names))
+	for _, name := range names {
+		conn := prh.connections[name]
+		spec.Nodes = append(spec.Nodes, connStringNode{Host: conn.Host(), Port: conn.Port()})
+		dsns = append(dsns, conn.PublicParams())
+	}
+
+	spec.Params = commonParams(dsns)
+
+	return spec.Format(format)
+}
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Connstring", func() {
+	Context("multiple hosts share client parameters", func() {
+		dsns := []pg.Dsn{
+			{"host": "10.0.0.1", "user": "app", "dbname": "appdb", "sslmode": "require", "pool_max_conns": "2"},
+			{"host": "10.0.0.2", "user": "app", "dbname": "appdb", "sslmode": "disable"},
+		}
+		It("should only keep common client parameters", func() {
+			Expect(commonParams(dsns)).To(Equal(pg.Dsn{"user": "app", "dbname": "appdb"}))
+		})
+	})
+	Context("a connection string is built", func() {
+		spec := connStringSpec{
+			Nodes:  []connStringNode{{Host: "10.0.0.1", Port: "5432"}, {Host: "10.0.0.2", Port: "5433"}},
+			Params: pg.Dsn{"user": "app", "dbname": "appdb", "application_name": "myapp"},
+			Attrs:  pg.TargetPreferStandby,
+		}
+		It("should build a libpq connection string", func() {
+			Expect(spec.Format(formatLibpq)).To(Equal("application_name='myapp' dbname='appdb' " +
+				"host='10.0.0.1,10.0.0.2' port='5432,5433' target_session_attrs='prefer-standby' user='app'"))
+		})
+		It("should build an uri", func() {
+			Expect(spec.Format(formatURI)).To(Equal("postgresql://app@10.0.0.1:5432,10.0.0.2:5433/appdb?" +
+				"application_name=myapp&target_session_attrs=prefer-standby"))
+		})
+		It("should build a jdbc url", func() {
+			Expect(spec.Format(formatJDBC)).To(Equal("jdbc:postgresql://10.0.0.1:5432,10.0.0.2:5433/appdb?" +
+				"ApplicationName=myapp&targetServerType=preferSecondary&user=app"))
+		})
+		It("should not build unknown formats", func() {
+			_, err := spec.Format("odbc")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,467 @@
+package internal
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+ * This module implements an authoritative DNS server for the configured zone, answering for:
+ * - primary.<group>.<zone>: A/AAAA records of the primary
+ * - standby.<group>.<zone>: A/AAAA records of all standbys, in round-robin order
+ * - <node>.<group>.<zone>: A/AAAA records of a node
+ * - _postgresql._tcp.<group>.<zone>: SRV records for the primary and all standbys
+ */
+
+const (
+	dnsUDPSize         = 512
+	dnsTCPSize         = 65535
+	dnsTCPLengthSize   = 2
+	dnsLookupTimeout   = 2 * time.Second
+	dnsAddressCacheAge = time.Minute
+	dnsSessionTimeout  = 10 * time.Second
+	dnsPrimaryLabel    = "primary"
+	dnsStandbyLabel    = "standby"
+	dnsServiceLabel    = "_postgresql"
+	dnsProtocolLabel   = "_tcp"
+	dnsStandbyPriority = 10
+	dnsSOARefresh      = 3600
+	dnsSOARetry        = 600
+	dnsSOAExpire       = 86400
+)
+
+// errDNSSplitBrain is returned when a primary is requested while a group has multiple primaries
+var errDNSSplitBrain = errors.New("multiple primaries")
+
+type dnsServer struct {
+	config   RouteDNSConfig
+	handler  *PgRouteHandler
+	balancer roundRobin
+}
+
+func newDNSServer(config RouteDNSConfig, handler *PgRouteHandler) *dnsServer {
+	return &dnsServer{
+		config:  config,
+		handler: handler,
+	}
+}
+
+// lookupAddresses returns the IP addresses of a host, which can also be an IP address itself
+func lookupAddresses(host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, addr := range addrs {
+		addrs[i] = addr.Unmap()
+	}
+
+	return addrs, nil
+}
+
+// nodeAddresses returns the IP addresses of the host of a node.
+// Addresses (and failures to resolve them) are cached for a minute, so that a host which cannot be resolved does not
+// stall (and log) every answer for its group.
+func (prh PgRouteHandler) nodeAddresses(name string) []netip.Addr {
+	conn, exists := prh.connections[name]
+	if !exists {
+		return nil
+	}
+
+	if addrs, cached := prh.addresses.get(name, dnsAddressCacheAge); cached {
+		return addrs
+	}
+
+	addrs, err := lookupAddresses(conn.Host())
+	if err != nil {
+		prh.log.Warnf("could not resolve host %s of node %s: %s", conn.Host(), name, err.Error())
+	}
+
+	prh.addresses.set(name, addrs)
+
+	return addrs
+}
+
+// rotate returns a copy of names which starts at the next position for every call
+func (ds *dnsServer) rotate(names []string) []string {
+	first, err := ds.balancer.pick(names)
+	if err != nil {
+		return nil
+	}
+
+	start := slices.Index(names, first)
+
+	return append(slices.Clone(names[start:]), names[:start]...)
+}
+
+// groupName returns the name of a group as defined in config, for a (lowercase) label
+func (ds *dnsServer) groupName(label string) (string, bool) {
+	if ds.handler.config.HasGroup(label) {
+		return label, true
+	}
+
+	for group := range ds.handler.config.Groups {
+		if strings.EqualFold(group, label) {
+			return group, true
+		}
+	}
+
+	return "", false
+}
+
+// resolveNames returns the node names a (zone relative) list of labels refers to,
+// and whether the name exists (a group without primary has no primary, but primary.<group> exists)
+func (ds *dnsServer) resolveNames(labels []string) (names []string, exists bool, err error) {
+	const nameLabels = 2
+
+	if len(labels) != nameLabels {
+		return nil, false, nil
+	}
+
+	kind := labels[0]
+
+	group, exists := ds.groupName(labels[1])
+	if !exists {
+		return nil, false, nil
+	}
+
+	switch kind {
+	case dnsPrimaryLabel:
+		primaries := ds.handler.GetPrimaries(group)
+		if len(primaries) > 1 {
+			return nil, true, errDNSSplitBrain
+		}
+
+		return primaries, true, nil
+	case dnsStandbyLabel:
+		return ds.rotate(ds.handler.GetStandbys(group)), true, nil
+	default:
+		// labels are lowercase, while node names are defined in config with any case
+		for _, name := range ds.handler.config.GroupHosts(group) {
+			if strings.EqualFold(name, kind) {
+				return []string{name}, true, nil
+			}
+		}
+
+		return nil, false, nil
+	}
+}
+
+func (ds *dnsServer) header(name dnsmessage.Name, rrType dnsmessage.Type) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{
+		Name:  name,
+		Type:  rrType,
+		Class: dnsmessage.ClassINET,
+		TTL:   ds.config.RecordTTL(),
+	}
+}
+
+func (ds *dnsServer) addressRecords(q dnsmessage.Question, names []string) (records []dnsmessage.Resource) {
+	for _, name := range names {
+		for _, addr := range ds.handler.nodeAddresses(name) {
+			if addr.Is4() && (q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeALL) {
+				records = append(records, dnsmessage.Resource{
+					Header: ds.header(q.Name, dnsmessage.TypeA),
+					Body:   &dnsmessage.AResource{A: addr.As4()},
+				})
+			} else if addr.Is6() && (q.Type == dnsmessage.TypeAAAA || q.Type == dnsmessage.TypeALL) {
+				records = append(records, dnsmessage.Resource{
+					Header: ds.header(q.Name, dnsmessage.TypeAAAA),
+					Body:   &dnsmessage.AAAAResource{AAAA: addr.As16()},
+				})
+			}
+		}
+	}
+
+	return records
+}
+
+func (ds *dnsServer) srvRecords(q dnsmessage.Question, group string) ([]dnsmessage.Resource, error) {
+	var records []dnsmessage.Resource
+
+	add := func(names []string, priority uint16) error {
+		for _, name := range names {
+			port, err := strconv.ParseUint(ds.handler.connections[name].Port(), 10, 16)
+			if err != nil {
+				return err
+			}
+
+			target, err := dnsmessage.NewName(name + "." + group + "." + ds.config.ZoneName())
+			if err != nil {
+				return err
+			}
+
+			records = append(records, dnsmessage.Resource{
+				Header: ds.header(q.Name, dnsmessage.TypeSRV),
+				Body:   &dnsmessage.SRVResource{Priority: priority, Weight: 1, Port: uint16(port), Target: target},
+			})
+		}
+
+		return nil
+	}
+
+	primaries := ds.handler.GetPrimaries(group)
+	if len(primaries) > 1 {
+		return nil, errDNSSplitBrain
+	} else if err := add(primaries, 0); err != nil {
+		return nil, err
+	} else if err = add(ds.handler.GetStandbys(group), dnsStandbyPriority); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (ds *dnsServer) soaRecord() (dnsmessage.Resource, error) {
+	zone, err := dnsmessage.NewName(ds.config.ZoneName())
+	if err != nil {
+		return dnsmessage.Resource{}, err
+	}
+
+	ns, err := dnsmessage.NewName(ds.config.NameServerName())
+	if err != nil {
+		return dnsmessage.Resource{}, err
+	}
+
+	mbox, err := dnsmessage.NewName("hostmaster." + ds.config.ZoneName())
+	if err != nil {
+		return dnsmessage.Resource{}, err
+	}
+
+	return dnsmessage.Resource{
+		Header: ds.header(zone, dnsmessage.TypeSOA),
+		Body: &dnsmessage.SOAResource{
+			NS:      ns,
+			MBox:    mbox,
+			Serial:  uint32(time.Now().Unix()),
+			Refresh: dnsSOARefresh,
+			Retry:   dnsSOARetry,
+			Expire:  dnsSOAExpire,
+			MinTTL:  ds.config.RecordTTL(),
+		},
+	}, nil
+}
+
+// answer returns the response code and the answer records for a question
+func (ds *dnsServer) answer(q dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource, error) {
+	name := strings.ToLower(q.Name.String())
+	zone := ds.config.ZoneName()
+
+	if name != zone && !strings.HasSuffix(name, "."+zone) {
+		return dnsmessage.RCodeRefused, nil, nil
+	}
+
+	if name == zone {
+		if q.Type != dnsmessage.TypeSOA && q.Type != dnsmessage.TypeALL {
+			return dnsmessage.RCodeSuccess, nil, nil
+		}
+
+		soa, err := ds.soaRecord()
+
+		return dnsmessage.RCodeSuccess, []dnsmessage.Resource{soa}, err
+	}
+
+	labels := strings.Split(strings.TrimSuffix(name, "."+zone), ".")
+
+	const srvLabels = 3
+	if len(labels) == srvLabels && labels[0] == dnsServiceLabel && labels[1] == dnsProtocolLabel {
+		group, exists := ds.groupName(labels[2])
+		if !exists {
+			return dnsmessage.RCodeNameError, nil, nil
+		} else if q.Type != dnsmessage.TypeSRV && q.Type != dnsmessage.TypeALL {
+			return dnsmessage.RCodeSuccess, nil, nil
+		}
+
+		records, err := ds.srvRecords(q, group)
+		if errors.Is(err, errDNSSplitBrain) {
+			return dnsmessage.RCodeServerFailure, nil, nil
+		}
+
+		return dnsmessage.RCodeSuccess, records, err
+	}
+
+	names, exists, err := ds.resolveNames(labels)
+	if errors.Is(err, errDNSSplitBrain) {
+		return dnsmessage.RCodeServerFailure, nil, nil
+	} else if err != nil {
+		return dnsmessage.RCodeServerFailure, nil, err
+	} else if !exists {
+		return dnsmessage.RCodeNameError, nil, nil
+	}
+
+	// a group without primary (or standbys) answers NODATA, as the name exists
+
+	return dnsmessage.RCodeSuccess, ds.addressRecords(q, names), nil
+}
+
+// respond parses a request and builds the response
+func (ds *dnsServer) respond(request []byte, maxSize int) ([]byte, error) {
+	var parser dnsmessage.Parser
+
+	header, err := parser.Start(request)
+	if err != nil {
+		return nil, err
+	}
+
+	response := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:            header.ID,
+			Response:      true,
+			OpCode:        header.OpCode,
+			Authoritative: true,
+		},
+	}
+
+	q, err := parser.Question()
+	if err != nil || header.OpCode != 0 {
+		response.RCode = dnsmessage.RCodeNotImplemented
+
+		return response.Pack()
+	}
+
+	response.Questions = []dnsmessage.Question{q}
+	response.RCode, response.Answers, err = ds.answer(q)
+	response.Authoritative = response.RCode != dnsmessage.RCodeRefused
+
+	if err != nil {
+		ds.handler.log.Errorf("could not answer dns question %s: %s", q.Name.String(), err.Error())
+		response.RCode = dnsmessage.RCodeServerFailure
+		response.Answers = nil
+	} else if len(response.Answers) == 0 && response.RCode != dnsmessage.RCodeRefused &&
+		response.RCode != dnsmessage.RCodeServerFailure {
+		// Negative answers carry the SOA for negative caching
+		soa, soaErr := ds.soaRecord()
+		if soaErr != nil {
+			return nil, soaErr
+		}
+
+		response.Authorities = []dnsmessage.Resource{soa}
+	}
+
+	packed, err := response.Pack()
+	if err != nil || len(packed) <= maxSize {
+		return packed, err
+	}
+
+	response.Truncated = true
+	response.Answers = nil
+	response.Authorities = nil
+
+	return response.Pack()
+}
+
+func (ds *dnsServer) serveUDP(conn net.PacketConn) {
+	buffer := make([]byte, dnsTCPSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			ds.handler.log.Errorf("dns server stopped reading udp requests: %s", err.Error())
+
+			return
+		}
+
+		request := slices.Clone(buffer[:n])
+
+		go func() {
+			response, respErr := ds.respond(request, dnsUDPSize)
+			if respErr != nil {
+				ds.handler.log.Debugf("invalid dns request from %s: %s", addr, respErr.Error())
+
+				return
+			}
+
+			_, _ = conn.WriteTo(response, addr)
+		}()
+	}
+}
+
+func (ds *dnsServer) handleTCP(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	for {
+		_ = conn.SetDeadline(time.Now().Add(dnsSessionTimeout))
+
+		length := make([]byte, dnsTCPLengthSize)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return
+		}
+
+		request := make([]byte, binary.BigEndian.Uint16(length))
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+
+		response, err := ds.respond(request, dnsTCPSize)
+		if err != nil {
+			ds.handler.log.Debugf("invalid dns request from %s: %s", conn.RemoteAddr(), err.Error())
+
+			return
+		}
+
+		binary.BigEndian.PutUint16(length, uint16(len(response)))
+
+		if _, err = conn.Write(append(length, response...)); err != nil {
+			return
+		}
+	}
+}
+
+func (ds *dnsServer) serveTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			ds.handler.log.Errorf("dns server stopped accepting tcp connections: %s", err.Error())
+
+			return
+		}
+
+		go ds.handleTCP(conn)
+	}
+}
+
+// RunDNS starts the DNS server (UDP and TCP) when it is enabled in config
+func (prh *PgRouteHandler) RunDNS() {
+	if !prh.config.DNS.Enabled() {
+		return
+	}
+
+	if err := prh.config.DNS.Validate(); err != nil {
+		prh.log.Fatal("Invalid dns config", err)
+	}
+
+	udpConn, err := net.ListenPacket("udp", prh.config.DNS.Listen)
+	if err != nil {
+		prh.log.Fatalf("Could not listen on udp %s: %s", prh.config.DNS.Listen, err.Error())
+	}
+
+	tcpListener, err := net.Listen("tcp", prh.config.DNS.Listen)
+	if err != nil {
+		prh.log.Fatalf("Could not listen on tcp %s: %s", prh.config.DNS.Listen, err.Error())
+	}
+
+	prh.log.Infof("Serving dns for zone %s on %s", prh.config.DNS.ZoneName(), prh.config.DNS.Listen)
+
+	ds := newDNSServer(prh.config.DNS, prh)
+
+	go ds.serveUDP(udpConn)
+	go ds.serveTCP(tcpListener)
+}
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"net/netip"
+
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func dnsQuery(ds *dnsServer, name string, rrType dnsmessage.Type) dnsmessage.Message {
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 66, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: rrType, Class: dnsmessage.ClassINET}},
+	}
+	request, err := query.Pack()
+	Expect(err).NotTo(HaveOccurred())
+
+	packed, err := ds.respond(request, dnsUDPSize)
+	Expect(err).NotTo(HaveOccurred())
+
+	var response dnsmessage.Message
+	Expect(response.Unpack(packed)).To(Succeed())
+	Expect(response.ID).To(Equal(uint16(66)))
+
+	return response
+}
+
+var _ = Describe("Dns", func() {
+	var ds *dnsServer
+
+	BeforeEach(func() {
+		ds = newDNSServer(RouteDNSConfig{Zone: "db.example.com"}, newTestHandler(RouteConfig{
+			Hosts: RouteHostsConfig{"Host1": {Dsn: pg.Dsn{"host": "10.0.0.1", "port": "5432"}}},
+			// group empty has no available members, so it never has a primary or standbys
+			Groups: RouteHostGroups{"Cluster": {"Host1"}, "empty": {"host9"}},
+		}))
+	})
+	Context("names of nodes", func() {
+		It("should answer case-insensitively", func() {
+			response := dnsQuery(ds, "host1.cluster.db.example.com.", dnsmessage.TypeA)
+			Expect(response.RCode).To(Equal(dnsmessage.RCodeSuccess))
+			Expect(response.Authoritative).To(BeTrue())
+			Expect(response.Answers).To(HaveLen(1))
+			Expect(response.Answers[0].Body).To(Equal(&dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}))
+		})
+		It("should answer NODATA for another record type", func() {
+			response := dnsQuery(ds, "HOST1.Cluster.db.example.com.", dnsmessage.TypeAAAA)
+			Expect(response.RCode).To(Equal(dnsmessage.RCodeSuccess))
+			Expect(response.Answers).To(BeEmpty())
+			Expect(response.Authorities).To(HaveLen(1))
+		})
+		It("should answer from the cached addresses of a node", func() {
+			ds.handler.addresses.set("Host1", []netip.Addr{netip.MustParseAddr("10.0.0.2")})
+			response := dnsQuery(ds, "host1.cluster.db.example.com.", dnsmessage.TypeA)
+			Expect(response.Answers).To(HaveLen(1))
+			Expect(response.Answers[0].Body).To(Equal(&dnsmessage.AResource{A: [4]byte{10, 0, 0, 2}}))
+		})
+		It("should answer NXDOMAIN for nodes that are not a member", func() {
+			response := dnsQuery(ds, "host2.cluster.db.example.com.", dnsmessage.TypeA)
+			Expect(response.RCode).To(Equal(dnsmessage.RCodeNameError))
+		})
+	})
+	Context("roles", func() {
+		It("should answer NODATA for a group without primary or standbys", func() {
+			for _, name := range []string{"primary.empty.db.example.com.", "standby.empty.db.example.com.",
+				"_postgresql._tcp.empty.db.example.com."} {
+				response := dnsQuery(ds, name, dnsmessage.TypeSRV)
+				Expect(response.RCode).To(Equal(dnsmessage.RCodeSuccess), name)
+				Expect(response.Answers).To(BeEmpty(), name)
+				Expect(response.Authorities).To(HaveLen(1), name)
+			}
+		})
+		It("should answer NXDOMAIN for groups that are not defined", func() {
+			response := dnsQuery(ds, "primary.other.db.example.com.", dnsmessage.TypeA)
+			Expect(response.RCode).To(Equal(dnsmessage.RCodeNameError))
+		})
+	})
+	Context("zone", func() {
+		It("should answer the SOA of the zone", func() {
+			response := dnsQuery(ds, "db.example.com.", dnsmessage.TypeSOA)
+			Expect(response.RCode).To(Equal(dnsmessage.RCodeSuccess))
+			Expect(response.Answers).To(HaveLen(1))
+			Expect(response.Answers[0].Header.Type).To(Equal(dnsmessage.TypeSOA))
+		})
+		It("should refuse names outside the zone", func() {
+			response := dnsQuery(ds, "www.example.org.", dnsmessage.TypeA)
+			Expect(response.RCode).To(Equal(dnsmessage.RCodeRefused))
+			Expect(response.Authoritative).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,322 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+)
+
+/*
+ * This module fails over groups that opted in, when their primary is unavailable:
+ *   - the primary should be unavailable for the grace period, and a quorum of standbys should confirm that their
+ *     WAL receiver lost it (so that a network issue between pgroute66 and the primary does not cause a failover)
+ *   - the best candidate (see routecandidates.go) is promoted: on the newest timeline, with most received WAL,
+ *     by priority on a tie, and preferring standbys that do not pause replay
+ *   - the old primary is fenced, and when it comes back as a primary, it is set to default_transaction_read_only.
+ *     When no primary was seen since pgroute66 started, all members that do not run as standby are fenced instead.
+ *   - after a promotion attempt, only the same target is promoted (with back-off) until it runs as primary,
+ *     or until it clearly failed: it still runs as standby after failoverMaxAttempts attempts.
+ *     Targets that clearly failed are not selected again, until the group has a primary again.
+ * Groups in maintenance, or in split brain, are never failed over (nor fenced).
+ */
+
+const (
+	// failoverHold means the group is in maintenance or split brain, and nothing is done
+	failoverHold = "hold"
+	// failoverWait means the group has a primary, or that the grace period (or back-off) did not pass yet
+	failoverWait = "wait"
+	// failoverStart means a failover to the best candidate is started
+	failoverStart = "start"
+	// failoverRetry means the promotion of the target of the last attempt is retried
+	failoverRetry = "retry"
+
+	failoverMaxAttempts = 3
+	failoverMaxBackOff  = time.Minute
+)
+
+// routeFailoverWatch holds the state of the failover of a group between checks
+type routeFailoverWatch struct {
+	// primary is the last primary of the group
+	primary string
+	// lostSince is when the group lost its primary
+	lostSince time.Time
+	// target is the standby that a promotion was attempted for, until it runs as primary or clearly failed
+	target   string
+	attempts int
+	retryAt  time.Time
+	// givenUp are the targets that clearly failed, which are not selected again until the group has a primary
+	givenUp []string
+}
+
+// decide returns what to do for a group with primaries, at now
+func (rfw *routeFailoverWatch) decide(now time.Time, primaries []string, maintenance bool,
+	grace time.Duration,
+) string {
+	switch {
+	case maintenance:
+		rfw.lostSince = time.Time{}
+
+		return failoverHold
+	case len(primaries) > 1:
+		rfw.lostSince = time.Time{}
+
+		return failoverHold
+	case len(primaries) == 1:
+		rfw.primary, rfw.lostSince, rfw.target, rfw.attempts = primaries[0], time.Time{}, "", 0
+		rfw.givenUp = nil
+
+		return failoverWait
+	case rfw.target != "" && now.Before(rfw.retryAt):
+		return failoverWait
+	case rfw.target != "":
+		return failoverRetry
+	case rfw.lostSince.IsZero():
+		rfw.lostSince = now
+	}
+
+	if now.Sub(rfw.lostSince) < grace {
+		return failoverWait
+	}
+
+	return failoverStart
+}
+
+// attempted records the result of promoting target. standby is whether the target still runs as standby.
+// It returns false when the target clearly failed, and a failover to another candidate may start.
+func (rfw *routeFailoverWatch) attempted(now time.Time, target string, err error, standby bool,
+	interval time.Duration,
+) bool {
+	rfw.target = target
+	if err != nil {
+		rfw.attempts++
+	}
+
+	if err != nil && standby && rfw.attempts >= failoverMaxAttempts {
+		rfw.target, rfw.attempts = "", 0
+		rfw.givenUp = append(rfw.givenUp, target)
+
+		return false
+	}
+
+	rfw.retryAt = now.Add(min(interval<<min(rfw.attempts, 8), failoverMaxBackOff))
+
+	return true
+}
+
+// groupStandbys returns all members of a group that run as standby, including orphaned standbys
+func (prh PgRouteHandler) groupStandbys(group string) (standbys []string) {
+	for name, conn := range prh.connections.FilteredConnections(prh.config.GroupHosts(group)) {
+		if isStandby, err := conn.IsStandby(context.Background()); err == nil && isStandby {
+			standbys = append(standbys, name)
+		}
+	}
+
+	sort.Strings(standbys)
+
+	return standbys
+}
+
+// lostPrimary returns how many standbys confirm that their WAL receiver does not stream from a primary
+func (prh PgRouteHandler) lostPrimary(standbys []string) (confirmed int) {
+	for _, name := range standbys {
+		receiver, exists, err := prh.connections[name].WalReceiver(context.Background())
+		if err != nil {
+			prh.log.Debugf("Could not get WAL receiver of standby %s, %s", name, err.Error())
+		} else if !exists || receiver.Status != walReceiverStreaming {
+			confirmed++
+		}
+	}
+
+	return confirmed
+}
+
+// needsFencing returns whether a fenced member runs as a primary that is not read-only (yet)
+func (prh PgRouteHandler) needsFencing(name string, state pg.NodeState) bool {
+	return prh.fenced.contains(name) && !state.InHotStandby && !state.DefaultTransactionReadOnly
+}
+
+// enforceFencing sets fenced members of a group that run as primary (e.a. an old primary that came back)
+// to default_transaction_read_only
+func (prh PgRouteHandler) enforceFencing(group string) {
+	for name, conn := range prh.connections.FilteredConnections(prh.config.GroupHosts(group)) {
+		if !prh.fenced.contains(name) {
+			continue
+		}
+
+		state, err := conn.NodeState(context.Background())
+		if err != nil || !prh.needsFencing(name, state) {
+			continue
+		}
+
+		prh.log.Warnf("Fenced node %s of group %s runs as primary, setting it read-only", name, group)
+
+		if err = conn.SetReadOnly(context.Background(), true); err != nil {
+			prh.log.Errorf("failed to fence node %s: %s", name, err.Error())
+		}
+	}
+}
+
+// failoverTarget returns the best ranked candidate that was not given up,
+// when enough standbys (confirmed of required) confirm that they lost the primary
+func failoverTarget(confirmed int, required int, candidates []RouteCandidate, givenUp []string) (RouteCandidate,
+	error,
+) {
+	if confirmed < required {
+		return RouteCandidate{}, fmt.Errorf("only %d of %d required standbys confirm that they lost the primary",
+			confirmed, required)
+	}
+
+	best, found := bestCandidate(slices.DeleteFunc(slices.Clone(candidates), func(candidate RouteCandidate) bool {
+		return slices.Contains(givenUp, candidate.Name)
+	}))
+	if !found {
+		return RouteCandidate{}, errors.New("no standby can be promoted")
+	}
+
+	return best, nil
+}
+
+// oldPrimaries returns the members of a group to fence on failover: the last primary, or when no primary was seen
+// since pgroute66 started, all members that do not run as standby (as any of them might be the lost primary)
+func (prh PgRouteHandler) oldPrimaries(group string, lastPrimary string, standbys []string) []string {
+	if lastPrimary != "" {
+		return []string{lastPrimary}
+	}
+
+	members := slices.DeleteFunc(slices.Clone(prh.config.GroupHosts(group)), func(name string) bool {
+		return slices.Contains(standbys, name)
+	})
+	sort.Strings(members)
+
+	return members
+}
+
+// failover promotes the best standby of a group (leaving out targets that were given up), and fences the old primary.
+// It returns the target when promotion was attempted, and an empty target when no standby can be promoted.
+func (prh PgRouteHandler) failover(group string, lastPrimary string, givenUp []string) (string, error) {
+	if !prh.topologyMutex.TryLock() {
+		return "", errors.New("another switchover or failover is running")
+	}
+	defer prh.topologyMutex.Unlock()
+
+	standbys := prh.groupStandbys(group)
+
+	best, err := failoverTarget(prh.lostPrimary(standbys), prh.config.Failover.RequiredQuorum(len(standbys)),
+		prh.rankedCandidates(group, standbys), givenUp)
+	if err != nil {
+		return "", err
+	}
+
+	// fence first, so that the old primary is never routed to, even when it comes back during promotion
+	oldPrimaries := prh.oldPrimaries(group, lastPrimary, standbys)
+	for _, name := range oldPrimaries {
+		prh.fenced.add(name)
+	}
+
+	prh.log.Warnf("Failing over group %s from %v to %s (received up to %d)", group, oldPrimaries, best.Name,
+		best.ReceivedLSN)
+
+	return best.Name, prh.promote(best.Name, prh.config.Switchover.SwitchoverTimeout())
+}
+
+// retryPromotion promotes the target of an earlier failover again
+func (prh PgRouteHandler) retryPromotion(group string, target string) error {
+	if !prh.topologyMutex.TryLock() {
+		return errors.New("another switchover or failover is running")
+	}
+	defer prh.topologyMutex.Unlock()
+
+	prh.log.Warnf("Retrying promotion of %s for group %s", target, group)
+
+	return prh.promote(target, prh.config.Switchover.SwitchoverTimeout())
+}
+
+// runsAsStandby returns whether a node is available, and runs as standby
+func (prh PgRouteHandler) runsAsStandby(name string) bool {
+	isStandby, err := prh.connections[name].IsStandby(context.Background())
+
+	return err == nil && isStandby
+}
+
+// watchGroup checks the primary of a group at every interval, and fails over when it is unavailable too long
+func (prh PgRouteHandler) watchGroup(group string, interval time.Duration) {
+	var watch routeFailoverWatch
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		primaries := prh.GetPrimaries(group)
+
+		action := watch.decide(time.Now(), primaries, prh.maintenance.contains(group),
+			prh.config.Failover.FailoverGrace())
+		if action == failoverHold {
+			if len(primaries) > 1 {
+				prh.log.Warnf("Not failing over group %s, which is in split brain (%v)", group, primaries)
+			}
+
+			continue
+		}
+
+		prh.enforceFencing(group)
+
+		var target string
+
+		var err error
+
+		switch action {
+		case failoverStart:
+			if target, err = prh.failover(group, watch.primary, watch.givenUp); target == "" {
+				prh.log.Errorf("Not failing over group %s: %s", group, err.Error())
+
+				continue
+			}
+		case failoverRetry:
+			target = watch.target
+			err = prh.retryPromotion(group, target)
+		default:
+			continue
+		}
+
+		if err != nil {
+			prh.log.Errorf("Failed to promote %s for group %s: %s", target, group, err.Error())
+		}
+
+		if !watch.attempted(time.Now(), target, err, err != nil && prh.runsAsStandby(target), interval) {
+			prh.log.Errorf("Giving up promotion of %s for group %s, which still runs as standby", target, group)
+		}
+	}
+}
+
+// RunFailover starts watching all groups that fail over automatically
+func (prh *PgRouteHandler) RunFailover() {
+	interval := prh.config.Failover.FailoverInterval()
+
+	for _, group := range prh.config.Failover.Groups {
+		prh.log.Infof("Failing over group %s automatically, after %s", group, prh.config.Failover.FailoverGrace())
+
+		go prh.watchGroup(group, interval)
+	}
+}
+
+// SetMaintenance puts a group in (or out of) maintenance, which prevents automatic failover
+func (prh PgRouteHandler) SetMaintenance(group string, maintenance bool) error {
+	if !prh.config.HasGroup(group) {
+		return fmt.Errorf("group %s is not defined", group)
+	}
+
+	if maintenance {
+		prh.log.Infof("Group %s is in maintenance", group)
+		prh.maintenance.add(group)
+	} else {
+		prh.log.Infof("Group %s is out of maintenance", group)
+		prh.maintenance.remove(group)
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Failover", func() {
+	grace, interval := 30*time.Second, 5*time.Second
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	errPromote := errors.New("promotion did not complete")
+	Context("deciding", func() {
+		It("should never act during maintenance or split brain", func() {
+			watch := routeFailoverWatch{primary: "host1", lostSince: start}
+			Expect(watch.decide(start.Add(time.Hour), nil, true, grace)).To(Equal(failoverHold))
+			Expect(watch.decide(start.Add(time.Hour), []string{"host1", "host2"}, false, grace)).
+				To(Equal(failoverHold))
+		})
+		It("should only fail over after the grace period", func() {
+			watch := routeFailoverWatch{}
+			Expect(watch.decide(start, []string{"host1"}, false, grace)).To(Equal(failoverWait))
+			Expect(watch.decide(start, nil, false, grace)).To(Equal(failoverWait))
+			Expect(watch.decide(start.Add(grace-time.Second), nil, false, grace)).To(Equal(failoverWait))
+			Expect(watch.decide(start.Add(grace), nil, false, grace)).To(Equal(failoverStart))
+		})
+		It("should fail over a group without primary since startup after the grace period", func() {
+			watch := routeFailoverWatch{}
+			Expect(watch.decide(start, nil, false, grace)).To(Equal(failoverWait))
+			Expect(watch.decide(start.Add(grace), nil, false, grace)).To(Equal(failoverStart))
+			Expect(watch.primary).To(BeEmpty())
+		})
+	})
+	Context("retrying", func() {
+		var watch routeFailoverWatch
+		BeforeEach(func() {
+			watch = routeFailoverWatch{primary: "host1", lostSince: start}
+			Expect(watch.decide(start.Add(grace), nil, false, grace)).To(Equal(failoverStart))
+		})
+		It("should only retry the same target, with back-off", func() {
+			Expect(watch.attempted(start.Add(grace), "host2", errPromote, false, interval)).To(BeTrue())
+			Expect(watch.decide(start.Add(grace+interval), nil, false, grace)).To(Equal(failoverWait))
+			Expect(watch.decide(start.Add(grace+2*interval), nil, false, grace)).To(Equal(failoverRetry))
+			Expect(watch.target).To(Equal("host2"))
+			Expect(watch.attempted(start.Add(grace+2*interval), "host2", errPromote, false, interval)).To(BeTrue())
+			Expect(watch.decide(start.Add(grace+5*interval), nil, false, grace)).To(Equal(failoverWait))
+			Expect(watch.decide(start.Add(grace+6*interval), nil, false, grace)).To(Equal(failoverRetry))
+		})
+		It("should keep retrying a target that is unavailable", func() {
+			for range 2 * failoverMaxAttempts {
+				Expect(watch.attempted(start, "host2", errPromote, false, interval)).To(BeTrue())
+			}
+			Expect(watch.target).To(Equal("host2"))
+			Expect(watch.retryAt).To(Equal(start.Add(failoverMaxBackOff)))
+		})
+		It("should wait for a promoted target to run as primary", func() {
+			Expect(watch.attempted(start.Add(grace), "host2", nil, false, interval)).To(BeTrue())
+			Expect(watch.decide(start.Add(grace+time.Second), nil, false, grace)).To(Equal(failoverWait))
+			Expect(watch.decide(start.Add(grace+interval), nil, false, grace)).To(Equal(failoverRetry))
+			Expect(watch.decide(start.Add(grace+interval), []string{"host2"}, false, grace)).To(Equal(failoverWait))
+			Expect(watch.target).To(BeEmpty())
+			Expect(watch.primary).To(Equal("host2"))
+		})
+		It("should give up a target that still runs as standby", func() {
+			for range failoverMaxAttempts - 1 {
+				Expect(watch.attempted(start.Add(grace), "host2", errPromote, true, interval)).To(BeTrue())
+			}
+			Expect(watch.attempted(start.Add(grace), "host2", errPromote, true, interval)).To(BeFalse())
+			Expect(watch.target).To(BeEmpty())
+			Expect(watch.decide(start.Add(grace), nil, false, grace)).To(Equal(failoverStart))
+		})
+		It("should remember given up targets until the group has a primary again", func() {
+			for range failoverMaxAttempts {
+				watch.attempted(start.Add(grace), "host2", errPromote, true, interval)
+			}
+			for range failoverMaxAttempts {
+				watch.attempted(start.Add(grace), "host3", errPromote, true, interval)
+			}
+			Expect(watch.givenUp).To(Equal([]string{"host2", "host3"}))
+			Expect(watch.decide(start.Add(grace), nil, true, grace)).To(Equal(failoverHold))
+			Expect(watch.givenUp).To(HaveLen(2))
+			Expect(watch.decide(start.Add(grace), []string{"host4"}, false, grace)).To(Equal(failoverWait))
+			Expect(watch.givenUp).To(BeEmpty())
+		})
+		It("should keep the target during maintenance", func() {
+			Expect(watch.attempted(start.Add(grace), "host2", errPromote, false, interval)).To(BeTrue())
+			Expect(watch.decide(start.Add(time.Hour), nil, true, grace)).To(Equal(failoverHold))
+			Expect(watch.decide(start.Add(time.Hour), nil, false, grace)).To(Equal(failoverRetry))
+		})
+	})
+	Context("choosing a target", func() {
+		best := func(candidates []RouteCandidate) string {
+			rankCandidates(candidates)
+			candidate, found := bestCandidate(candidates)
+			if !found {
+				return ""
+			}
+
+			return candidate.Name
+		}
+		It("should prefer the newest timeline over most WAL", func() {
+			Expect(best([]RouteCandidate{
+				{Name: "host2", Eligible: true, Timeline: 2, ReceivedLSN: 400},
+				{Name: "host3", Eligible: true, Timeline: 3, ReceivedLSN: 200},
+			})).To(Equal("host3"))
+		})
+		It("should prefer running replay over paused replay on a tie", func() {
+			Expect(best([]RouteCandidate{
+				{Name: "host2", Eligible: true, ReceivedLSN: 300, ReplayPaused: true},
+				{Name: "host3", Eligible: true, ReceivedLSN: 300},
+			})).To(Equal("host3"))
+		})
+		It("should never promote ineligible candidates", func() {
+			Expect(best(nil)).To(BeEmpty())
+			Expect(best([]RouteCandidate{
+				{Name: "host2", NoFailover: true, ReceivedLSN: 400},
+				{Name: "host3", Error: "connection refused"},
+			})).To(BeEmpty())
+		})
+	})
+	Context("selecting the failover target", func() {
+		candidates := []RouteCandidate{
+			{Name: "host2", Eligible: true, ReceivedLSN: 300},
+			{Name: "host3", Eligible: true, ReceivedLSN: 200},
+			{Name: "host4", NoFailover: true, ReceivedLSN: 400},
+		}
+		BeforeEach(func() {
+			rankCandidates(candidates)
+		})
+		It("should not fail over without a quorum of standbys that lost the primary", func() {
+			_, err := failoverTarget(1, 2, candidates, nil)
+			Expect(err).To(MatchError(ContainSubstring("only 1 of 2 required standbys")))
+		})
+		It("should select the best candidate with a quorum", func() {
+			target, err := failoverTarget(2, 2, candidates, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(target.Name).To(Equal("host2"))
+		})
+		It("should select the next best candidate after giving up a target", func() {
+			target, err := failoverTarget(2, 2, candidates, []string{"host2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(target.Name).To(Equal("host3"))
+		})
+		It("should not select ineligible candidates after giving up all eligible targets", func() {
+			_, err := failoverTarget(2, 2, candidates, []string{"host2", "host3"})
+			Expect(err).To(MatchError("no standby can be promoted"))
+		})
+	})
+	Context("fencing", func() {
+		var handler *PgRouteHandler
+		BeforeEach(func() {
+			handler = newTestHandler(RouteConfig{Groups: RouteHostGroups{"cluster": {"host3", "host1", "host2"}}})
+		})
+		It("should fence the last primary", func() {
+			Expect(handler.oldPrimaries("cluster", "host1", []string{"host2"})).To(Equal([]string{"host1"}))
+		})
+		It("should fence all members that do not run as standby without a last primary", func() {
+			Expect(handler.oldPrimaries("cluster", "", []string{"host2"})).To(Equal([]string{"host1", "host3"}))
+		})
+		It("should only set fenced members that accept writes to read-only", func() {
+			handler.fenced.add("host1")
+			Expect(handler.needsFencing("host1", pg.NodeState{})).To(BeTrue())
+			Expect(handler.needsFencing("host1", pg.NodeState{DefaultTransactionReadOnly: true})).To(BeFalse())
+			Expect(handler.needsFencing("host1", pg.NodeState{InHotStandby: true})).To(BeFalse())
+			Expect(handler.needsFencing("host2", pg.NodeState{})).To(BeFalse())
+		})
+	})
+})
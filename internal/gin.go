@@ -3,12 +3,16 @@ package internal
 
 import (
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
 )
 
 // RunAPI will run the gin webserver
@@ -18,17 +22,19 @@ func RunAPI() {
 	var cert tls.Certificate
 
 	Initialize()
+	globalHandler.RunProxies()
+	globalHandler.RunPgRouters()
+	globalHandler.RunDNS()
+	globalHandler.RunHeartbeats()
+	globalHandler.RunClockSkew()
+	globalHandler.RunStats()
+	globalHandler.RunFailover()
 
 	if !globalHandler.config.Debug() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	router := gin.Default()
-	router.GET("/v1/primary", getPrimary)
-	router.GET("/v1/primaries", getPrimaries)
-	router.GET("/v1/standbys", getStandbys)
-	router.GET("/v1/:id/status", getStatus)
-	router.GET("/v1/:id/availability", getAvailability)
+	router := newRouter()
 
 	globalHandler.log.Debugf("Running on %s", globalHandler.config.BindTo())
 
@@ -56,27 +62,416 @@ func RunAPI() {
 	}
 }
 
+func paramStrategy() apiParam {
+	return queryParam("strategy", "load balancing strategy", strategyRoundRobin, strategyRoundRobin, strategyRandom,
+		strategyLeastLag, strategyWeighted)
+}
+
+func paramFallback() apiParam {
+	return queryParam("fallback", "set to primary to fall back to the primary when no standby is available", "",
+		ghStatusPrimary)
+}
+
+func paramAttrs(defaultValue pg.TargetSessionAttrs) apiParam {
+	return queryParam("attrs", "target_session_attrs, with the same semantics as libpq", string(defaultValue),
+		string(pg.TargetAny), string(pg.TargetReadWrite), string(pg.TargetReadOnly), string(pg.TargetPrimary),
+		string(pg.TargetStandby), string(pg.TargetPreferStandby))
+}
+
+func paramConnStringFormat() apiParam {
+	return queryParam("format", "format of the connection string", formatLibpq, formatLibpq, formatURI, formatJDBC)
+}
+
+func paramRequireSync() apiParam {
+	return queryParam("require_sync", "set to true to only return the primary when it has the number of "+
+		"synchronous standbys required by synchronous_standby_names", "false", "true", "false")
+}
+
+func paramLimit() apiParam {
+	return queryParam("limit", "maximum number of seconds since the last heartbeat, or empty for no limit", "10")
+}
+
+// v1Routes returns all routes of the v1 api
+func v1Routes() []apiRoute {
+	nodeParams := append([]apiParam{paramGroup(), paramNodeFormat()}, paramsNodeFilter()...)
+
+	return []apiRoute{
+		{Method: http.MethodGet, Path: "/v1/primary", Handler: getPrimary, Summary: "the primary of a group",
+			Params: append([]apiParam{paramRequireSync()}, nodeParams...), Statuses: map[int]string{
+				http.StatusNotFound:           "no primary",
+				http.StatusConflict:           "multiple primaries (split brain)",
+				http.StatusServiceUnavailable: "too few synchronous standbys (with require_sync)",
+			}},
+		{Method: http.MethodGet, Path: "/v1/primaries", Handler: getPrimaries, Summary: "all primaries of a group",
+			Params: nodeParams, Schema: schemaStrings()},
+		{Method: http.MethodGet, Path: "/v1/standbys", Handler: getStandbys, Summary: "all standbys of a group",
+			Params: nodeParams, Schema: schemaStrings()},
+		{Method: http.MethodGet, Path: "/v1/sync_standbys", Handler: getSyncStandbys,
+			Summary: "all connected synchronous standbys of the primary of a group",
+			Params:  []apiParam{paramGroup(), paramNodeFormat()}, Schema: schemaStrings(), Statuses: map[int]string{
+				http.StatusNotFound: "no primary",
+				http.StatusConflict: "multiple primaries (split brain)",
+			}},
+		{Method: http.MethodGet, Path: "/v1/standby", Handler: getStandby, Summary: "one standby of a group",
+			Params: append([]apiParam{paramStrategy(), paramFallback()}, nodeParams...),
+			Statuses: map[int]string{
+				http.StatusBadRequest: "invalid strategy",
+				http.StatusNotFound:   "no standby",
+			}},
+		{Method: http.MethodGet, Path: "/v1/nodes", Handler: getNodes, Summary: "inventory of all nodes of a group",
+			Params: append([]apiParam{paramGroup()}, paramsNodeFilter()...), Schema: schemaNodes()},
+		{Method: http.MethodGet, Path: "/v1/connstring", Handler: getConnString,
+			Summary: "multi-host connection string for all nodes of a group",
+			Params:  []apiParam{paramGroup(), paramAttrs(pg.TargetReadWrite), paramConnStringFormat()},
+			Statuses: map[int]string{
+				http.StatusBadRequest: "invalid attrs or format",
+			}},
+		{Method: http.MethodGet, Path: "/v1/target", Handler: getTarget,
+			Summary: "all nodes of a group that match target_session_attrs",
+			Params:  []apiParam{paramGroup(), paramAttrs(pg.TargetAny), paramNodeFormat()}, Schema: schemaStrings(),
+			Statuses: map[int]string{
+				http.StatusBadRequest: "invalid attrs",
+			}},
+		{Method: http.MethodGet, Path: "/v1/groups", Handler: getGroups, Summary: "names of all groups",
+			Schema: schemaStrings()},
+		{Method: http.MethodGet, Path: "/v1/groups/:name", Handler: getGroup,
+			Summary: "members, roles and health of a group", Params: []apiParam{paramGroupName()},
+			Schema: schemaGroup(), Statuses: map[int]string{
+				http.StatusNotFound: "group is not defined",
+			}},
+		{Method: http.MethodGet, Path: "/v1/groups/:name/tree", Handler: getTree,
+			Summary: "replication tree of a group, including cascading standbys", Params: []apiParam{paramGroupName()},
+			Schema: schemaTree(), Statuses: map[int]string{
+				http.StatusNotFound: "group is not defined",
+			}},
+		{Method: http.MethodGet, Path: "/v1/groups/:name/availability", Handler: getGroupAvailability,
+			Summary: "availability of all members of a group", Params: []apiParam{paramGroupName(), paramLimit()},
+			Schema: schemaGroupAvailability(), Statuses: map[int]string{
+				http.StatusNotFound:       "group is not defined",
+				http.StatusRequestTimeout: "not all members are available",
+			}},
+		{Method: http.MethodGet, Path: "/v1/groups/:name/stats", Handler: getGroupStats,
+			Summary: "availability statistics of a group over the last 1h, 24h and 7d",
+			Params:  []apiParam{paramGroupName()}, Schema: schemaGroupStats(), Statuses: map[int]string{
+				http.StatusNotFound: "group is not defined",
+			}},
+		{Method: http.MethodGet, Path: "/v1/groups/:name/candidates", Handler: getCandidates,
+			Summary: "standbys of a group, ranked as candidates for promotion", Params: []apiParam{paramGroupName()},
+			Schema: schemaCandidates(), Statuses: map[int]string{
+				http.StatusNotFound: "group is not defined",
+			}},
+		{Method: http.MethodPost, Path: "/v1/groups/:name/switchover", Handler: postSwitchover,
+			Summary: "switch the primary of a group over to a standby", Params: []apiParam{paramGroupName()},
+			Body: schemaSwitchoverRequest(), Schema: schemaSwitchover(), Admin: true, Statuses: map[int]string{
+				http.StatusBadRequest:          "invalid request body",
+				http.StatusNotFound:            "group is not defined",
+				http.StatusConflict:            "switchover rejected (a precondition is not met)",
+				http.StatusInternalServerError: "a step of the switchover failed",
+			}},
+		{Method: http.MethodPost, Path: "/v1/groups/:name/maintenance", Handler: postMaintenance,
+			Summary: "put a group in maintenance, which prevents automatic failover",
+			Params:  []apiParam{paramGroupName()}, Schema: schemaBoolean(), Admin: true, Statuses: map[int]string{
+				http.StatusNotFound: "group is not defined",
+			}},
+		{Method: http.MethodDelete, Path: "/v1/groups/:name/maintenance", Handler: deleteMaintenance,
+			Summary: "take a group out of maintenance", Params: []apiParam{paramGroupName()}, Schema: schemaBoolean(),
+			Admin: true, Statuses: map[int]string{
+				http.StatusNotFound: "group is not defined",
+			}},
+		{Method: http.MethodGet, Path: "/v1/:id/status", Handler: getStatus, Summary: "status of a node",
+			Params: []apiParam{paramNodeID()}, Statuses: map[int]string{
+				http.StatusNotFound:            "node is not defined",
+				http.StatusUnprocessableEntity: "node is not available",
+			}},
+		{Method: http.MethodGet, Path: "/v1/:id/availability", Handler: getAvailability,
+			Summary: "availability of a node", Params: []apiParam{paramNodeID(), paramLimit()},
+			Statuses: map[int]string{
+				http.StatusRequestTimeout:    "limit exceeded",
+				http.StatusExpectationFailed: "availability could not be checked",
+			}},
+	}
+}
+
+// groupParam returns the group query parameter, which defaults to all.
+// When the group is not defined, a bad request is sent, and ok is false.
+func groupParam(c *gin.Context) (group string, ok bool) {
+	group = c.DefaultQuery("group", "all")
+	if globalHandler.config.HasGroup(group) {
+		return group, true
+	}
+
+	render(c, http.StatusBadRequest, fmt.Sprintf("group %s is not defined", group))
+
+	return "", false
+}
+
+// nodeFilter returns the filter defined by the tag and prefer query parameters (e.a. ?tag=zone:dc1)
+func nodeFilter(c *gin.Context) RouteNodeFilter {
+	return NewRouteNodeFilter(c.QueryArray("tag"), c.QueryArray("prefer"))
+}
+
 func getPrimary(c *gin.Context) {
-	primary := globalHandler.GetPrimaries(c.DefaultQuery("group", "all"))
+	group, ok := groupParam(c)
+	if !ok {
+		return
+	}
+
+	primary := globalHandler.GetPrimaries(group)
+	if len(primary) == 1 {
+		primary = nodeFilter(c).Apply(globalHandler.config.Hosts, primary)
+	}
+
 	switch len(primary) {
 	case 0:
-		c.IndentedJSON(http.StatusNotFound, "")
+		render(c, http.StatusNotFound, "")
 	case 1:
-		c.IndentedJSON(http.StatusOK, primary[0])
+		if err := requireSync(c, group); err != nil {
+			render(c, http.StatusServiceUnavailable, err.Error())
+		} else {
+			renderNode(c, http.StatusOK, primary[0])
+		}
 	default:
-		c.IndentedJSON(http.StatusConflict, "")
+		render(c, http.StatusConflict, "")
+	}
+}
+
+// requireSync returns an error when the require_sync query parameter is true,
+// and the primary of the group has fewer synchronous standbys than required
+func requireSync(c *gin.Context, group string) error {
+	if c.Query("require_sync") != "true" {
+		return nil
 	}
+
+	state, err := globalHandler.GetSyncState(group)
+	if err != nil {
+		return err
+	}
+
+	return state.Error()
 }
 
-// getPrimaries responds with the list of all albums as JSON.
+// getSyncStandbys responds with the connected synchronous standbys of the primary.
+func getSyncStandbys(c *gin.Context) {
+	group, ok := groupParam(c)
+	if !ok {
+		return
+	}
+
+	state, err := globalHandler.GetSyncState(group)
+
+	switch {
+	case errors.Is(err, errNoPrimary):
+		render(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, errMultiplePrimaries):
+		render(c, http.StatusConflict, err.Error())
+	case err != nil:
+		render(c, http.StatusInternalServerError, err.Error())
+	default:
+		renderNodes(c, http.StatusOK, state.Standbys)
+	}
+}
+
+// getPrimaries responds with the list of all primaries as JSON.
 func getPrimaries(c *gin.Context) {
-	primaries := globalHandler.GetPrimaries(c.DefaultQuery("group", "all"))
-	c.IndentedJSON(http.StatusOK, primaries)
+	group, ok := groupParam(c)
+	if !ok {
+		return
+	}
+
+	primaries := globalHandler.GetPrimaries(group)
+	renderNodes(c, http.StatusOK, nodeFilter(c).Apply(globalHandler.config.Hosts, primaries))
 }
 
-// getStandbys responds with the list of all albums as JSON.
+// getStandbys responds with the list of all standbys as JSON.
 func getStandbys(c *gin.Context) {
-	c.IndentedJSON(http.StatusOK, globalHandler.GetStandbys(c.DefaultQuery("group", "all")))
+	group, ok := groupParam(c)
+	if !ok {
+		return
+	}
+
+	standbys := globalHandler.GetStandbys(group)
+	renderNodes(c, http.StatusOK, nodeFilter(c).Apply(globalHandler.config.Hosts, standbys))
+}
+
+// getNodes responds with the inventory of all nodes as JSON.
+func getNodes(c *gin.Context) {
+	group, ok := groupParam(c)
+	if !ok {
+		return
+	}
+
+	render(c, http.StatusOK, globalHandler.GetNodes(group, nodeFilter(c)))
+}
+
+func getStandby(c *gin.Context) {
+	group, ok := groupParam(c)
+	if !ok {
+		return
+	}
+
+	standby, err := globalHandler.SelectStandby(group,
+		c.DefaultQuery("strategy", strategyRoundRobin), nodeFilter(c), c.Query("fallback") == ghStatusPrimary)
+	if err != nil {
+		render(c, http.StatusBadRequest, err.Error())
+	} else if standby == "" {
+		render(c, http.StatusNotFound, "")
+	} else {
+		renderNode(c, http.StatusOK, standby)
+	}
+}
+
+func getConnString(c *gin.Context) {
+	group, ok := groupParam(c)
+	if !ok {
+		return
+	}
+
+	attrs, err := pg.NewTargetSessionAttrs(c.DefaultQuery("attrs", string(pg.TargetReadWrite)))
+	if err != nil {
+		render(c, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	connString, err := globalHandler.GetConnString(group, attrs,
+		c.DefaultQuery("format", formatLibpq))
+	if err != nil {
+		render(c, http.StatusBadRequest, err.Error())
+	} else {
+		render(c, http.StatusOK, connString)
+	}
+}
+
+// getTarget responds with the list of all nodes matching target_session_attrs as JSON.
+func getTarget(c *gin.Context) {
+	group, ok := groupParam(c)
+	if !ok {
+		return
+	}
+
+	attrs, err := pg.NewTargetSessionAttrs(c.DefaultQuery("attrs", string(pg.TargetAny)))
+	if err != nil {
+		render(c, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	renderNodes(c, http.StatusOK, globalHandler.GetTargets(group, attrs))
+}
+
+// getGroups responds with the names of all groups as JSON.
+func getGroups(c *gin.Context) {
+	render(c, http.StatusOK, globalHandler.GetGroups())
+}
+
+// getGroup responds with the members, roles and health of a group as JSON.
+func getGroup(c *gin.Context) {
+	summary, err := globalHandler.GetGroup(c.Param("name"))
+	if err != nil {
+		render(c, http.StatusNotFound, err.Error())
+	} else {
+		render(c, http.StatusOK, summary)
+	}
+}
+
+// getTree responds with the replication tree of a group.
+func getTree(c *gin.Context) {
+	tree, err := globalHandler.GetTree(c.Param("name"))
+	if err != nil {
+		render(c, http.StatusNotFound, err.Error())
+	} else {
+		render(c, http.StatusOK, tree)
+	}
+}
+
+// getGroupAvailability responds with the availability of all members of a group.
+func getGroupAvailability(c *gin.Context) {
+	limit, err := availabilityLimit(c)
+	if err != nil {
+		globalHandler.log.Error(err.Error())
+	}
+
+	availability, err := globalHandler.GetGroupAvailability(c.Param("name"), limit)
+
+	switch {
+	case err != nil:
+		render(c, http.StatusNotFound, err.Error())
+	case availability.Available < availability.Total:
+		render(c, http.StatusRequestTimeout, availability)
+	default:
+		render(c, http.StatusOK, availability)
+	}
+}
+
+// getGroupStats responds with the availability statistics of a group.
+func getGroupStats(c *gin.Context) {
+	stats, err := globalHandler.GetGroupStats(c.Param("name"))
+	if err != nil {
+		render(c, http.StatusNotFound, err.Error())
+	} else {
+		render(c, http.StatusOK, stats)
+	}
+}
+
+// getCandidates responds with the standbys of a group, ranked as candidates for promotion.
+func getCandidates(c *gin.Context) {
+	candidates, err := globalHandler.GetCandidates(c.Param("name"))
+	if err != nil {
+		render(c, http.StatusNotFound, err.Error())
+	} else {
+		render(c, http.StatusOK, candidates)
+	}
+}
+
+// switchoverRequest is the request body of a switchover
+type switchoverRequest struct {
+	Target string `json:"target" binding:"required"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// postSwitchover switches the primary of a group over, and responds with the report of all steps.
+func postSwitchover(c *gin.Context) {
+	group := c.Param("name")
+	if !globalHandler.config.HasGroup(group) {
+		render(c, http.StatusNotFound, fmt.Sprintf("group %s is not defined", group))
+
+		return
+	}
+
+	var request switchoverRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		render(c, http.StatusBadRequest, fmt.Sprintf("invalid request body: %s", err.Error()))
+
+		return
+	}
+
+	report, err := globalHandler.Switchover(group, request.Target, request.DryRun)
+
+	switch {
+	case err == nil:
+		render(c, http.StatusOK, report)
+	case errors.Is(err, errSwitchoverRejected):
+		render(c, http.StatusConflict, report)
+	default:
+		render(c, http.StatusInternalServerError, report)
+	}
+}
+
+// setMaintenance puts a group in or out of maintenance, and responds with whether the group is in maintenance.
+func setMaintenance(c *gin.Context, maintenance bool) {
+	if err := globalHandler.SetMaintenance(c.Param("name"), maintenance); err != nil {
+		render(c, http.StatusNotFound, err.Error())
+	} else {
+		render(c, http.StatusOK, maintenance)
+	}
+}
+
+func postMaintenance(c *gin.Context) {
+	setMaintenance(c, true)
+}
+
+func deleteMaintenance(c *gin.Context) {
+	setMaintenance(c, false)
 }
 
 func getStatus(c *gin.Context) {
@@ -85,33 +480,43 @@ func getStatus(c *gin.Context) {
 	status := globalHandler.GetNodeStatus(id)
 	switch status {
 	case ghStatusPrimary, ghStatusStandby:
-		c.IndentedJSON(http.StatusOK, status)
+		render(c, http.StatusOK, status)
 	case ghStatusInvalid:
-		c.IndentedJSON(http.StatusNotFound, status)
+		render(c, http.StatusNotFound, status)
 	case ghStatusUnavailable:
-		c.IndentedJSON(http.StatusUnprocessableEntity, status)
+		render(c, http.StatusUnprocessableEntity, status)
 	}
 }
 
-func getAvailability(c *gin.Context) {
-	id := c.Param("id")
+// availabilityLimit returns the value of the limit query parameter (in seconds), or -1 when it is empty
+func availabilityLimit(c *gin.Context) (time.Duration, error) {
+	value := c.DefaultQuery("limit", "10")
+	if value == "" {
+		return -1, nil
+	}
 
-	var limit float64
+	limit, err := strconv.ParseFloat(value, bitSize32)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid value for limit (%s is not a positive number of seconds)", value)
+	}
 
-	var err error
+	return time.Duration(limit * float64(time.Second)), nil
+}
 
-	if value := c.DefaultQuery("limit", "10"); value == "" {
-		limit = -1
-	} else if limit, err = strconv.ParseFloat(value, bitSize32); err != nil {
-		globalHandler.log.Errorf("invalid value for limit (%s is not an int32)", value)
+func getAvailability(c *gin.Context) {
+	id := c.Param("id")
+
+	limit, err := availabilityLimit(c)
+	if err != nil {
+		globalHandler.log.Error(err.Error())
 	}
 
 	status := globalHandler.GetNodeAvailability(id, limit)
 	if status == ghStatusOk {
-		c.IndentedJSON(http.StatusOK, status)
+		render(c, http.StatusOK, status)
 	} else if strings.HasPrefix(status, "exceeded") {
-		c.IndentedJSON(http.StatusRequestTimeout, status)
+		render(c, http.StatusRequestTimeout, status)
 	} else {
-		c.IndentedJSON(http.StatusExpectationFailed, status)
+		render(c, http.StatusExpectationFailed, status)
 	}
 }
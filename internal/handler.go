@@ -3,10 +3,15 @@ package internal
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/mannemsolutions/pgroute66/pkg/pg"
 	"go.uber.org/zap"
@@ -21,6 +26,9 @@ const (
 	ghStatusUnavailable = "unavailable"
 )
 
+// errUndefinedNode is returned for nodes that are not defined in config
+var errUndefinedNode = errors.New("node is not defined")
+
 const (
 	pgrOpenMode   = os.O_APPEND | os.O_CREATE | os.O_WRONLY
 	pgrCreateMode = 0o644
@@ -31,7 +39,29 @@ type PgRouteHandler struct {
 	log         *zap.SugaredLogger
 	atom        zap.AtomicLevel
 	connections RouteConnections
-	config      RouteConfig
+	// heartbeatConnections holds connections to the databases the heartbeat is stored in (when configured)
+	heartbeatConnections map[string]RouteConnections
+	config               RouteConfig
+	// standbyBalancer is used for selecting standbys round-robin
+	standbyBalancer *roundRobin
+	// heartbeatSeq is the sequence number of the last heartbeat written by this process
+	heartbeatSeq *atomic.Int64
+	// stats holds the rolling availability statistics of all groups
+	stats *routeStats
+	// fenced holds the nodes that are left out of routing answers, as they are fenced by a switchover or failover
+	fenced *lockedSet
+	// maintenance holds the groups in maintenance, which are never failed over
+	maintenance *lockedSet
+	// timelines caches the timelines of all groups
+	timelines *routeGroupCache[map[string]RouteNodeTimeline]
+	// addresses caches the resolved IP addresses of all nodes
+	addresses *routeGroupCache[[]netip.Addr]
+	// clockSkews holds the clock skew of all nodes, as last measured in the background
+	clockSkews *routeClockSkews
+	// conflicts caches the foreign and duplicate members of all groups
+	conflicts *routeGroupCache[map[string]string]
+	// topologyMutex makes sure that only one switchover (or failover) runs at a time
+	topologyMutex *sync.Mutex
 }
 
 /*
@@ -47,13 +77,29 @@ func Initialize() {
 	globalHandler = NewPgRouteHandler()
 }
 
+// newPgRouteHandler returns a PgRouteHandler without config and connections, with all state initialized
+func newPgRouteHandler() PgRouteHandler {
+	return PgRouteHandler{
+		connections:          map[string]*pg.Conn{},
+		heartbeatConnections: map[string]RouteConnections{},
+		standbyBalancer:      &roundRobin{},
+		heartbeatSeq:         &atomic.Int64{},
+		stats:                newRouteStats(),
+		fenced:               newLockedSet(),
+		maintenance:          newLockedSet(),
+		timelines:            newRouteGroupCache[map[string]RouteNodeTimeline](),
+		conflicts:            newRouteGroupCache[map[string]string](),
+		clockSkews:           newRouteClockSkews(),
+		addresses:            newRouteGroupCache[[]netip.Addr](),
+		topologyMutex:        &sync.Mutex{},
+	}
+}
+
 // NewPgRouteHandler returns a PgRouteHandler
 func NewPgRouteHandler() *PgRouteHandler {
 	var err error
 
-	prh := PgRouteHandler{
-		connections: map[string]*pg.Conn{},
-	}
+	prh := newPgRouteHandler()
 
 	prh.config, err = NewConfig()
 	if err != nil {
@@ -64,7 +110,12 @@ func NewPgRouteHandler() *PgRouteHandler {
 	prh.initLogger(prh.config.LogFile)
 	prh.enableDebug(prh.config.Debug())
 
-	for name, dsn := range prh.config.Hosts {
+	for name, hostConfig := range prh.config.Hosts {
+		dsn := hostConfig.Dsn
+		if dsn == nil {
+			dsn = pg.Dsn{}
+		}
+
 		if b64password, exists := dsn["b64password"]; exists {
 			sDec, err := base64.StdEncoding.DecodeString(b64password)
 			if err != nil {
@@ -79,10 +130,32 @@ func NewPgRouteHandler() *PgRouteHandler {
 		prh.connections[name] = pg.NewConn(dsn, prh.log)
 	}
 
+	if err = prh.config.Heartbeat.Validate(); err != nil {
+		prh.log.Fatal("Invalid heartbeat config", err)
+	}
+
+	for _, database := range prh.config.Heartbeat.Databases() {
+		prh.heartbeatConnections[database] = RouteConnections{}
+		for name, conn := range prh.connections {
+			prh.heartbeatConnections[database][name] = conn.WithDatabase(database)
+		}
+	}
+
+	if prh.config.AutoGroups {
+		prh.addAutoGroups()
+	}
+
+	// after adding auto groups, which can fail over automatically as well
+	if err = prh.config.ValidateFailover(); err != nil {
+		prh.log.Fatal("Invalid failover config", err)
+	}
+
 	return &prh
 }
 
-// GetStandbys connects all PostgreSQL servers and returns a list of all that are standby
+// GetStandbys connects all PostgreSQL servers and returns a list of all that are standby.
+// Standbys that diverged from the timeline of the primary or are misconfigured (foreign or duplicate) are left out,
+// and with exclude_orphaned_standbys, also standbys without streaming WAL receiver.
 func (prh PgRouteHandler) GetStandbys(group string) (standbys []string) {
 	for name, conn := range prh.connections.FilteredConnections(prh.config.GroupHosts(group)) {
 		isStandby, err := conn.IsStandby(context.Background())
@@ -90,32 +163,90 @@ func (prh PgRouteHandler) GetStandbys(group string) (standbys []string) {
 			prh.log.Debugf("Could not get state of standby %s, %s", name, err.Error())
 		}
 
-		if isStandby {
+		if isStandby && prh.config.ExcludeOrphanedStandbys && prh.isOrphaned(name) {
+			prh.log.Debugf("Leaving out orphaned standby %s", name)
+		} else if isStandby {
 			standbys = append(standbys, name)
 		}
 	}
 
 	sort.Strings(standbys)
 
-	return standbys
+	return prh.withoutRebuilds(group, prh.withoutConflicts(group, standbys))
 }
 
-// GetPrimaries connects all PostgreSQL servers and returns a list of all that are primary
+// GetPrimaries connects all PostgreSQL servers and returns a list of all that are primary.
+// Primaries that are fenced by a switchover (or read-only by default_transaction_read_only) are left out,
+// until they run as standby, and so are misconfigured (foreign or duplicate) primaries.
 func (prh PgRouteHandler) GetPrimaries(group string) (primaries []string) {
 	for name, conn := range prh.connections.FilteredConnections(prh.config.GroupHosts(group)) {
-		isPrimary, err := conn.IsPrimary(context.Background())
+		state, err := conn.NodeState(context.Background())
 		if err != nil {
 			prh.log.Debugf("Could not get state of primary %s, %s", name, err.Error())
+
+			continue
 		}
 
-		if isPrimary {
+		if state.InHotStandby && prh.fenced.contains(name) {
+			prh.log.Infof("Fenced node %s runs as standby, and is no longer fenced", name)
+			prh.fenced.remove(name)
+		} else if !state.InHotStandby && (prh.fenced.contains(name) || state.Fenced()) {
+			prh.log.Debugf("Leaving out fenced primary %s", name)
+		} else if !state.InHotStandby {
 			primaries = append(primaries, name)
 		}
 	}
 
 	sort.Strings(primaries)
 
-	return primaries
+	return prh.withoutConflicts(group, primaries)
+}
+
+// isFencedPrimary returns whether a node that runs as primary is fenced, by this instance or because it runs with
+// default_transaction_read_only (e.a. fenced before a restart, or by another instance of pgroute66)
+func (prh PgRouteHandler) isFencedPrimary(name string) bool {
+	if prh.fenced.contains(name) {
+		return true
+	}
+
+	state, err := prh.connections[name].NodeState(context.Background())
+
+	return err == nil && state.Fenced()
+}
+
+// GetTargets returns all nodes of a group that match target_session_attrs, with the same semantics as libpq.
+// For prefer-standby all standbys are returned, or all available nodes when there is no standby.
+// Nodes that diverged from the timeline of the primary are never returned for reads.
+func (prh PgRouteHandler) GetTargets(group string, attrs pg.TargetSessionAttrs) (targets []string) {
+	var available []string
+
+	for name, conn := range prh.connections.FilteredConnections(prh.config.GroupHosts(group)) {
+		state, err := conn.NodeState(context.Background())
+		if err != nil {
+			prh.log.Debugf("Could not get state of node %s, %s", name, err.Error())
+
+			continue
+		}
+
+		available = append(available, name)
+
+		if attrs.Matches(state) {
+			targets = append(targets, name)
+		}
+	}
+
+	if len(targets) == 0 && attrs == pg.TargetPreferStandby {
+		targets = available
+	}
+
+	sort.Strings(targets)
+
+	targets = prh.withoutConflicts(group, targets)
+	if attrs == pg.TargetReadWrite || attrs == pg.TargetPrimary {
+		return targets
+	}
+
+	return prh.withoutRebuilds(group, targets)
 }
 
 // GetNodeStatus returns a status for a node
@@ -135,63 +266,70 @@ func (prh PgRouteHandler) GetNodeStatus(name string) string {
 	return ghStatusInvalid
 }
 
-// UpdateNodeAvailability on the primary
-func (prh PgRouteHandler) UpdateNodeAvailability() {
-	for nodeName, conn := range prh.connections {
-		if isPrimary, err := conn.IsPrimary(context.Background()); err != nil {
-			prh.log.Errorf("failed to check if node %s is primary: %e", nodeName, err)
-		} else if !isPrimary {
-			continue
-		} else if err = conn.AvUpdateDuration(context.Background()); err != nil {
-			prh.log.Errorf("failed to update availability info on node %s: %e", nodeName, err)
-			return
-		} else {
-			prh.log.Infof("updating availability info on node %s", nodeName)
+// heartbeatConnection returns the connection to a node, to the database the heartbeat is stored in
+func (prh PgRouteHandler) heartbeatConnection(name string, storage RouteHeartbeatStorage) *pg.Conn {
+	if conn, exists := prh.heartbeatConnections[storage.Database][name]; exists {
+		return conn
+	}
 
-			return
-		}
+	return prh.connections[name]
+}
+
+// CheckNodeAvailability checks that the last heartbeat on a node is at most limit ago (without limit when negative),
+// and returns an error when it is not. With correct in the clock_skew config, clock skew is corrected for.
+func (prh PgRouteHandler) CheckNodeAvailability(name string, limit time.Duration) error {
+	if _, exists := prh.connections[name]; !exists {
+		return errUndefinedNode
 	}
+
+	storage := prh.config.NodeStorage(name)
+
+	avc := storage.Avc(prh.config.Instance())
+	if avc.Backend == pg.AvcBackendWAL {
+		avc.PrimaryLSN = prh.primaryLSN(name)
+	}
+
+	return prh.heartbeatConnection(name, storage).AvCheckDuration(context.Background(), avc, limit,
+		prh.availabilityCorrection(name))
 }
 
-// CreateAvailabilityTable creates the AVC table
-func (prh PgRouteHandler) CreateAvailabilityTable() {
-	for nodeName, conn := range prh.connections {
-		if isPrimary, err := conn.IsPrimary(context.Background()); err != nil {
-			prh.log.Errorf("failed to check if node %s is primary: %e", nodeName, err)
-		} else if !isPrimary {
-			continue
-		} else if err = conn.AvcCreateTable(context.Background()); err != nil {
-			prh.log.Errorf("failed to create availability table on node %s: %e", nodeName, err)
+// primaryLSN returns the current WAL location of the primary of a node, or 0 when it is unknown
+func (prh PgRouteHandler) primaryLSN(name string) int64 {
+	primary, err := prh.nodePrimary(name)
+	if err != nil {
+		return 0
+	}
 
-			return
-		} else {
-			prh.log.Infof("creating availability table on node %s", nodeName)
+	lsn, err := prh.connections[primary].CurrentLSN(context.Background())
+	if err != nil {
+		prh.log.Debugf("Could not get WAL location of primary %s, %s", primary, err.Error())
 
-			return
-		}
+		return 0
 	}
+
+	return lsn
 }
 
 // GetNodeAvailability returns the state of one node
-func (prh PgRouteHandler) GetNodeAvailability(name string, limit float64) string {
-	prh.CreateAvailabilityTable()
-	defer prh.UpdateNodeAvailability()
+func (prh PgRouteHandler) GetNodeAvailability(name string, limit time.Duration) string {
+	err := prh.CheckNodeAvailability(name, limit)
+	if err == nil {
+		prh.log.Infof("availability of node %s is within limits", name)
+
+		return ghStatusOk
+	} else if errors.Is(err, errUndefinedNode) {
+		return ghStatusInvalid
+	} else if aErr, ok := err.(pg.AvcDurationExceededError); ok {
+		prh.log.Infof("Availability limit exceeded for %s: %e", name, aErr)
+		return fmt.Sprintf("exceeded (%s)", aErr.String())
+	} else if errors.Is(err, pg.ErrAvcPermissionDenied) || errors.Is(err, pg.ErrAvcTableMissing) ||
+		errors.Is(err, pg.ErrAvcNoHeartbeat) {
+		prh.log.Warnf("Cannot check availability of %s: %s", name, err.Error())
 
-	if node, exists := prh.connections[name]; exists {
-		err := node.AvCheckDuration(context.Background(), limit)
-		if err == nil {
-			prh.log.Infof("availability of node %s is within limits", name)
-
-			return ghStatusOk
-		} else if aErr, ok := err.(pg.AvcDurationExceededError); ok {
-			prh.log.Infof("Availability limit exceeded for %s: %e", name, aErr)
-			return fmt.Sprintf("exceeded (%s)", aErr.String())
-		}
-		prh.log.Errorf("unexpeced error occurred while retrieving availability of %s: %e", name, err)
 		return err.Error()
 	}
-
-	return ghStatusInvalid
+	prh.log.Errorf("unexpeced error occurred while retrieving availability of %s: %e", name, err)
+	return err.Error()
 }
 
 func (prh *PgRouteHandler) initLogger(logFilePath string) {
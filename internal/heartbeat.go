@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+)
+
+/*
+ * This module writes a heartbeat on the primary of every group at a fixed interval.
+ * The availability of a node is the time since the last heartbeat it replayed, so that checking it is a pure read.
+ * Every instance of pgroute66 writes its own row (by instance_id), with the writing process and a sequence number.
+ * When pgroute66 lacks privileges, this is logged once, and writing is retried with back-off until it succeeds.
+ */
+
+// heartbeatMaxBackOff is the longest interval between attempts to write a heartbeat without privileges
+const heartbeatMaxBackOff = time.Minute
+
+// heartbeatGroups returns the groups to write a heartbeat for: all groups (or all nodes without groups),
+// except for groups where the heartbeat is only checked
+func (prh PgRouteHandler) heartbeatGroups() (groups []string) {
+	candidates := prh.GetGroups()
+	if len(candidates) == 0 {
+		candidates = []string{"all"}
+	}
+
+	for _, group := range candidates {
+		if prh.config.Heartbeat.Storage(group).Writes() {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// heartbeatWriter returns the identity of this process (hostname:pid) and the next sequence number
+func (prh PgRouteHandler) heartbeatWriter() pg.AvcWriter {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	return pg.AvcWriter{
+		Writer: fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+		Seq:    prh.heartbeatSeq.Add(1),
+	}
+}
+
+// writeHeartbeat writes the heartbeat on the primary of a group (creating the heartbeat table when required)
+func (prh PgRouteHandler) writeHeartbeat(group string) error {
+	primary, err := prh.singlePrimary(group)
+	if err != nil {
+		prh.log.Debugf("not writing heartbeat for group %s: %s", group, err.Error())
+
+		return nil
+	}
+
+	storage := prh.config.Heartbeat.Storage(group)
+	if err = prh.heartbeatConnection(primary, storage).AvUpdateDuration(context.Background(),
+		storage.Avc(prh.config.Instance()), storage.Creates(), prh.heartbeatWriter()); err != nil {
+		return fmt.Errorf("failed to write heartbeat on node %s: %w", primary, err)
+	}
+
+	return nil
+}
+
+// heartbeatBackOff returns the wait before the next heartbeat, which backs off after consecutive denials
+func heartbeatBackOff(interval time.Duration, denials int) time.Duration {
+	return min(interval<<min(denials, 8), max(interval, heartbeatMaxBackOff))
+}
+
+// heartbeat writes the heartbeat for a group at every interval.
+// Missing privileges are only logged for the first denial, after which writing backs off.
+func (prh PgRouteHandler) heartbeat(group string, interval time.Duration) {
+	var denials int
+
+	for {
+		err := prh.writeHeartbeat(group)
+
+		switch {
+		case err == nil:
+			if denials > 0 {
+				prh.log.Infof("heartbeat for group %s is written again", group)
+			}
+
+			denials = 0
+		case !errors.Is(err, pg.ErrAvcPermissionDenied):
+			prh.log.Error(err.Error())
+		case denials == 0:
+			prh.log.Errorf("%s (retrying with back-off up to %s)", err.Error(), heartbeatMaxBackOff)
+
+			denials++
+		default:
+			prh.log.Debug(err.Error())
+
+			denials++
+		}
+
+		time.Sleep(heartbeatBackOff(interval, denials))
+	}
+}
+
+// RunHeartbeats starts writing the heartbeat for every group in the background
+func (prh *PgRouteHandler) RunHeartbeats() {
+	interval := prh.config.Heartbeat.HeartbeatInterval()
+
+	for _, group := range prh.heartbeatGroups() {
+		prh.log.Infof("Writing heartbeat for group %s every %s", group, interval)
+
+		go prh.heartbeat(group, interval)
+	}
+}
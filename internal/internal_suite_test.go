@@ -3,11 +3,28 @@ package internal
 import (
 	"testing"
 
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
 )
 
 func TestInternal(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Internal Suite")
 }
+
+// newTestHandler returns a handler for config, with a connection for every host, and sets it as the global handler.
+// Connections are only opened when a test queries a node.
+func newTestHandler(config RouteConfig) *PgRouteHandler {
+	handler := newPgRouteHandler()
+	handler.log, handler.config = zap.NewNop().Sugar(), config
+
+	for name, hostConfig := range config.Hosts {
+		handler.connections[name] = pg.NewConn(hostConfig.Dsn, handler.log)
+	}
+
+	globalHandler = &handler
+
+	return &handler
+}
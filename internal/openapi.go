@@ -0,0 +1,388 @@
+package internal
+
+import (
+	_ "embed"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+ * This module documents all routes of the api, and serves the documentation as an OpenAPI 3 document.
+ * Routes are registered from the same list that is used to build the document, so every route is documented.
+ */
+
+const openAPIVersion = "3.0.3"
+
+//go:embed docs.html
+var docsPage []byte
+
+// apiParam documents a parameter of a route
+type apiParam struct {
+	Name        string
+	In          string
+	Description string
+	Default     string
+	Enum        []string
+	Repeatable  bool
+}
+
+// apiRoute is a route of the api, together with its documentation
+type apiRoute struct {
+	Method  string
+	Path    string
+	Handler gin.HandlerFunc
+	Summary string
+	Params  []apiParam
+	// Schema is the schema of the answer, or of the data in the envelope for v2 routes
+	Schema map[string]any
+	// Statuses are the documented status codes of the answer (besides 200)
+	Statuses map[int]string
+	// V2 routes answer with the v2 envelope
+	V2 bool
+	// Body is the schema of the JSON request body, if any
+	Body map[string]any
+	// Admin routes change the cluster, and require the admin token
+	Admin bool
+}
+
+func queryParam(name string, description string, defaultValue string, enum ...string) apiParam {
+	return apiParam{Name: name, In: "query", Description: description, Default: defaultValue, Enum: enum}
+}
+
+func paramGroup() apiParam {
+	return queryParam("group", "group of nodes as defined in config, or all for all nodes", "all")
+}
+
+func paramGroupName() apiParam {
+	return apiParam{Name: "name", In: "path", Description: "name of the group as defined in config"}
+}
+
+func paramNodeID() apiParam {
+	return apiParam{Name: "id", In: "path", Description: "name of the node as defined in config"}
+}
+
+func paramNodeFormat() apiParam {
+	return queryParam("format", "format of the nodes in the answer", nodeFormatName, nodeFormatName,
+		nodeFormatHostPort, nodeFormatDSN)
+}
+
+// paramsNodeFilter returns the params to filter nodes by tags
+func paramsNodeFilter() []apiParam {
+	return []apiParam{
+		{Name: "tag", In: "query", Repeatable: true, Description: "only nodes with this tag (key:value or key)"},
+		{Name: "prefer", In: "query", Repeatable: true,
+			Description: "prefer nodes with this tag (key:value or key), falling back to the next preference"},
+	}
+}
+
+func schemaString() map[string]any {
+	return map[string]any{"type": "string"}
+}
+
+func schemaBoolean() map[string]any {
+	return map[string]any{"type": "boolean"}
+}
+
+func schemaStrings() map[string]any {
+	return map[string]any{"type": "array", "items": schemaString()}
+}
+
+func schemaObject(properties map[string]map[string]any) map[string]any {
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+func schemaNodes() map[string]any {
+	return map[string]any{"type": "array", "items": schemaObject(map[string]map[string]any{
+		"name":   schemaString(),
+		"host":   schemaString(),
+		"port":   schemaString(),
+		"weight": {"type": "integer"},
+		"tags":   {"type": "object", "additionalProperties": schemaString()},
+		"groups": schemaStrings(),
+	})}
+}
+
+func schemaGroup() map[string]any {
+	return schemaObject(map[string]map[string]any{
+		"name":               schemaString(),
+		"members":            schemaStrings(),
+		"primary":            schemaString(),
+		"primaries":          schemaStrings(),
+		"standbys":           schemaStrings(),
+		"unavailable":        schemaStrings(),
+		"fenced":             schemaStrings(),
+		"maintenance":        schemaBoolean(),
+		"health":             schemaString(),
+		"replication":        {"type": "object", "additionalProperties": schemaString()},
+		"timelines":          {"type": "object", "additionalProperties": map[string]any{"type": "integer"}},
+		"needs_rebuild":      schemaStrings(),
+		"system_identifiers": {"type": "object", "additionalProperties": schemaString()},
+		"misconfigured":      {"type": "object", "additionalProperties": schemaString()},
+		"clock_skew":         {"type": "object", "additionalProperties": map[string]any{"type": "number"}},
+	})
+}
+
+func schemaTree() map[string]any {
+	return map[string]any{"type": "array", "items": schemaObject(map[string]map[string]any{
+		"name":        schemaString(),
+		"role":        schemaString(),
+		"replication": schemaString(),
+		"upstream":    schemaString(),
+		"sender":      schemaString(),
+		"state":       schemaString(),
+		"sync_state":  schemaString(),
+		"children":    {"type": "array", "items": map[string]any{"type": "object"}},
+	})}
+}
+
+func schemaGroupAvailability() map[string]any {
+	return schemaObject(map[string]map[string]any{
+		"name":      schemaString(),
+		"available": {"type": "integer"},
+		"total":     {"type": "integer"},
+		"nodes": {"type": "object", "additionalProperties": schemaObject(map[string]map[string]any{
+			"status":  schemaString(),
+			"message": schemaString(),
+		})},
+	})
+}
+
+func schemaGroupStats() map[string]any {
+	return schemaObject(map[string]map[string]any{
+		"name": schemaString(),
+		"windows": {"type": "object", "additionalProperties": schemaObject(map[string]map[string]any{
+			"observed":        {"type": "number"},
+			"primary_present": {"type": "number"},
+			"split_brain":     {"type": "number"},
+			"uptime":          {"type": "object", "additionalProperties": map[string]any{"type": "number"}},
+		})},
+	})
+}
+
+func schemaCandidates() map[string]any {
+	return map[string]any{"type": "array", "items": schemaObject(map[string]map[string]any{
+		"name":          schemaString(),
+		"rank":          {"type": "integer"},
+		"eligible":      schemaBoolean(),
+		"timeline":      {"type": "integer"},
+		"received_lsn":  {"type": "integer"},
+		"replayed_lsn":  {"type": "integer"},
+		"priority":      {"type": "integer"},
+		"nofailover":    schemaBoolean(),
+		"replay_paused": schemaBoolean(),
+		"needs_rebuild": schemaBoolean(),
+		"reason":        schemaString(),
+		"error":         schemaString(),
+	})}
+}
+
+func schemaSwitchoverRequest() map[string]any {
+	return schemaObject(map[string]map[string]any{
+		"target":  schemaString(),
+		"dry_run": {"type": "boolean"},
+	})
+}
+
+func schemaSwitchover() map[string]any {
+	return schemaObject(map[string]map[string]any{
+		"group":   schemaString(),
+		"primary": schemaString(),
+		"target":  schemaString(),
+		"dry_run": {"type": "boolean"},
+		"steps": {"type": "array", "items": schemaObject(map[string]map[string]any{
+			"step":    schemaString(),
+			"status":  schemaString(),
+			"message": schemaString(),
+		})},
+	})
+}
+
+func schemaV2Envelope(data map[string]any) map[string]any {
+	return schemaObject(map[string]map[string]any{
+		"data": data,
+		"error": schemaObject(map[string]map[string]any{
+			"code":    schemaString(),
+			"message": schemaString(),
+		}),
+		"timestamp": {"type": "string", "format": "date-time"},
+	})
+}
+
+// OpenAPIPath returns the path in OpenAPI notation (/v1/{id}/status instead of /v1/:id/status)
+func (ar apiRoute) OpenAPIPath() string {
+	parts := strings.Split(ar.Path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+func (ap apiParam) document() map[string]any {
+	schema := schemaString()
+	if ap.Default != "" {
+		schema["default"] = ap.Default
+	}
+
+	if len(ap.Enum) > 0 {
+		schema["enum"] = ap.Enum
+	}
+
+	if ap.Repeatable {
+		schema = map[string]any{"type": "array", "items": schema}
+	}
+
+	return map[string]any{
+		"name":        ap.Name,
+		"in":          ap.In,
+		"description": ap.Description,
+		"required":    ap.In == "path",
+		"schema":      schema,
+	}
+}
+
+func (ar apiRoute) document() map[string]any {
+	schema := ar.Schema
+	if schema == nil {
+		schema = schemaString()
+	}
+
+	if ar.V2 {
+		schema = schemaV2Envelope(schema)
+	}
+
+	content := map[string]any{
+		gin.MIMEJSON:  map[string]any{"schema": schema},
+		gin.MIMEYAML2: map[string]any{"schema": schema},
+		gin.MIMEPlain: map[string]any{"schema": schemaString()},
+	}
+
+	responses := map[string]any{
+		strconv.Itoa(http.StatusOK): map[string]any{"description": "success", "content": content},
+	}
+
+	for status, description := range ar.Statuses {
+		responses[strconv.Itoa(status)] = map[string]any{"description": description, "content": content}
+	}
+
+	if ar.Admin && !ar.V2 {
+		responses[strconv.Itoa(http.StatusUnauthorized)] = map[string]any{
+			"description": "invalid or missing bearer token", "content": content,
+		}
+		responses[strconv.Itoa(http.StatusForbidden)] = map[string]any{
+			"description": "admin api is disabled", "content": content,
+		}
+	}
+
+	badRequest := strconv.Itoa(http.StatusBadRequest)
+	if _, documented := responses[badRequest]; !documented && !ar.V2 && slices.ContainsFunc(ar.Params,
+		func(param apiParam) bool { return param.Name == paramGroup().Name }) {
+		responses[badRequest] = map[string]any{"description": "group is not defined", "content": content}
+	}
+
+	if ar.V2 {
+		responses["default"] = map[string]any{
+			"description": "error, with the status code as configured in status_codes for the error code",
+			"content":     content,
+		}
+	}
+
+	params := make([]map[string]any, 0, len(ar.Params))
+	for _, param := range ar.Params {
+		params = append(params, param.document())
+	}
+
+	document := map[string]any{
+		"summary":    ar.Summary,
+		"parameters": params,
+		"responses":  responses,
+	}
+
+	if ar.Body != nil {
+		document["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{gin.MIMEJSON: map[string]any{"schema": ar.Body}},
+		}
+	}
+
+	if ar.Admin {
+		document["security"] = []map[string][]string{{"bearer": {}}}
+	}
+
+	return document
+}
+
+// openAPIDocument returns the OpenAPI document for a list of routes
+func openAPIDocument(routes []apiRoute) map[string]any {
+	paths := map[string]map[string]any{}
+
+	for _, route := range routes {
+		path := route.OpenAPIPath()
+		if _, exists := paths[path]; !exists {
+			paths[path] = map[string]any{}
+		}
+
+		paths[path][strings.ToLower(route.Method)] = route.document()
+	}
+
+	return map[string]any{
+		"openapi": openAPIVersion,
+		"info": map[string]any{
+			"title":       "pgroute66",
+			"description": "directs routers to the correct postgres primary",
+			"version":     appVersion,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{"bearer": map[string]any{"type": "http", "scheme": "bearer"}},
+		},
+	}
+}
+
+// docsRoutes returns the routes that serve the documentation of the api
+func docsRoutes() []apiRoute {
+	return []apiRoute{
+		{Method: http.MethodGet, Path: "/openapi.json", Handler: getOpenAPI, Summary: "OpenAPI document of this api",
+			Schema: map[string]any{"type": "object"}},
+		{Method: http.MethodGet, Path: "/docs", Handler: getDocs, Summary: "documentation page of this api"},
+	}
+}
+
+// apiRoutes returns all routes of the api
+func apiRoutes() []apiRoute {
+	var routes []apiRoute
+
+	routes = append(routes, v1Routes()...)
+	routes = append(routes, v2Routes()...)
+
+	return append(routes, docsRoutes()...)
+}
+
+// newRouter returns a gin engine with all api routes
+func newRouter() *gin.Engine {
+	router := gin.Default()
+
+	for _, route := range apiRoutes() {
+		if route.Admin {
+			router.Handle(route.Method, route.Path, requireAdmin(route.V2), route.Handler)
+		} else {
+			router.Handle(route.Method, route.Path, route.Handler)
+		}
+	}
+
+	return router
+}
+
+func getOpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, openAPIDocument(apiRoutes()))
+}
+
+func getDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Openapi", func() {
+	gin.SetMode(gin.TestMode)
+
+	router := newRouter()
+
+	// the document is read as served by the router, and compared with the routes gin registered
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	var document struct {
+		Paths map[string]map[string]struct {
+			Summary    string `json:"summary"`
+			Parameters []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+		} `json:"paths"`
+	}
+	It("should serve the document as valid json", func() {
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &document)).To(Succeed())
+		Expect(document.Paths).NotTo(BeEmpty())
+	})
+	Context("routes are registered with gin", func() {
+		BeforeEach(func() {
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &document)).To(Succeed())
+		})
+		It("should document every route", func() {
+			Expect(router.Routes()).NotTo(BeEmpty())
+
+			for _, route := range router.Routes() {
+				path := apiRoute{Path: route.Path}.OpenAPIPath()
+				Expect(document.Paths).To(HaveKey(path), "route %s is not documented", route.Path)
+
+				operation, documented := document.Paths[path][strings.ToLower(route.Method)]
+				Expect(documented).To(BeTrue(), "method %s of route %s is not documented", route.Method, route.Path)
+				Expect(operation.Summary).NotTo(BeEmpty(), "route %s %s has no summary", route.Method, route.Path)
+			}
+		})
+		It("should only document registered routes", func() {
+			var documented int
+			for _, methods := range document.Paths {
+				documented += len(methods)
+			}
+
+			Expect(documented).To(Equal(len(router.Routes())))
+		})
+		It("should document every path parameter", func() {
+			for _, route := range router.Routes() {
+				operation := document.Paths[apiRoute{Path: route.Path}.OpenAPIPath()][strings.ToLower(route.Method)]
+
+				for _, part := range strings.Split(route.Path, "/") {
+					if !strings.HasPrefix(part, ":") {
+						continue
+					}
+
+					Expect(operation.Parameters).To(ContainElement(And(
+						HaveField("Name", part[1:]), HaveField("In", "path"))),
+						"parameter %s of route %s is not documented", part, route.Path)
+				}
+			}
+		})
+		It("should convert path parameters", func() {
+			Expect(document.Paths).To(HaveKey("/v1/{id}/status"))
+		})
+	})
+})
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+ * This module implements the small part of the PostgreSQL frontend/backend protocol that is required to route a
+ * client connection: reading the startup packet, and refusing SSL / GSSAPI encryption requests.
+ */
+
+const (
+	pgProtocolVersion3   = 196608
+	pgCancelRequestCode  = 80877102
+	pgSSLRequestCode     = 80877103
+	pgGSSENCRequestCode  = 80877104
+	pgMaxStartupLength   = 10000
+	pgInt32Size          = 4
+	pgEncryptionRefused  = 'N'
+	pgErrorResponse      = 'E'
+	pgConnectionFailure  = "08006"
+	pgStartupParamUser   = "user"
+	pgStartupParamDBName = "database"
+)
+
+// pgStartupMessage is the startup packet a client sends when connecting
+type pgStartupMessage struct {
+	// Raw holds the complete packet (including length) so it can be forwarded as is
+	Raw        []byte
+	Parameters map[string]string
+}
+
+// User returns the user the client wants to connect as
+func (psm pgStartupMessage) User() string {
+	return psm.Parameters[pgStartupParamUser]
+}
+
+// Database returns the database the client wants to connect to, which defaults to the user name
+func (psm pgStartupMessage) Database() string {
+	if db, exists := psm.Parameters[pgStartupParamDBName]; exists && db != "" {
+		return db
+	}
+
+	return psm.User()
+}
+
+func readPgPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, pgInt32Size)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length < 2*pgInt32Size || length > pgMaxStartupLength {
+		return nil, fmt.Errorf("invalid startup packet length %d", length)
+	}
+
+	packet := make([]byte, length)
+	copy(packet, header)
+
+	if _, err := io.ReadFull(r, packet[pgInt32Size:]); err != nil {
+		return nil, err
+	}
+
+	return packet, nil
+}
+
+func parsePgStartupParameters(body []byte) (map[string]string, error) {
+	params := map[string]string{}
+
+	fields := bytes.Split(body, []byte{0})
+	// A proper body ends with a terminating zero after the last key/value pair, resulting in two empty fields
+	if len(fields) < 2 || len(fields[len(fields)-1]) != 0 || len(fields[len(fields)-2]) != 0 {
+		return nil, errors.New("startup packet is not properly terminated")
+	}
+
+	fields = fields[:len(fields)-2]
+	if len(fields)%2 != 0 {
+		return nil, errors.New("startup packet has a key without value")
+	}
+
+	for i := 0; i < len(fields); i += 2 {
+		params[string(fields[i])] = string(fields[i+1])
+	}
+
+	return params, nil
+}
+
+// readPgStartupMessage reads the startup packet from a client.
+// SSL and GSSAPI encryption requests are refused, after which the client is expected to send a plain startup packet.
+func readPgStartupMessage(rw io.ReadWriter) (psm pgStartupMessage, err error) {
+	for {
+		var packet []byte
+
+		if packet, err = readPgPacket(rw); err != nil {
+			return psm, err
+		}
+
+		switch code := binary.BigEndian.Uint32(packet[pgInt32Size:]); code {
+		case pgSSLRequestCode, pgGSSENCRequestCode:
+			if _, err = rw.Write([]byte{pgEncryptionRefused}); err != nil {
+				return psm, err
+			}
+		case pgCancelRequestCode:
+			return psm, errors.New("cancel requests cannot be routed")
+		case pgProtocolVersion3:
+			psm.Raw = packet
+			psm.Parameters, err = parsePgStartupParameters(packet[2*pgInt32Size:])
+
+			return psm, err
+		default:
+			return psm, fmt.Errorf("unsupported protocol version %d.%d", code>>16, code&0xffff)
+		}
+	}
+}
+
+// pgFatalMessage returns an ErrorResponse message which can be sent to a client before closing the connection
+func pgFatalMessage(message string) []byte {
+	var body bytes.Buffer
+
+	for _, field := range []struct {
+		code  byte
+		value string
+	}{
+		{'S', "FATAL"},
+		{'V', "FATAL"},
+		{'C', pgConnectionFailure},
+		{'M', message},
+	} {
+		body.WriteByte(field.code)
+		body.WriteString(field.value)
+		body.WriteByte(0)
+	}
+
+	body.WriteByte(0)
+
+	msg := make([]byte, 1+pgInt32Size, 1+pgInt32Size+body.Len())
+	msg[0] = pgErrorResponse
+	binary.BigEndian.PutUint32(msg[1:], uint32(pgInt32Size+body.Len()))
+
+	return append(msg, body.Bytes()...)
+}
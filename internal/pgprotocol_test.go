@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func pgPacket(code uint32, body []byte) []byte {
+	packet := make([]byte, 2*pgInt32Size, 2*pgInt32Size+len(body))
+	binary.BigEndian.PutUint32(packet, uint32(2*pgInt32Size+len(body)))
+	binary.BigEndian.PutUint32(packet[pgInt32Size:], code)
+
+	return append(packet, body...)
+}
+
+var _ = Describe("Pgprotocol", func() {
+	var (
+		startupBody = []byte("user\x00app\x00database\x00appdb\x00\x00")
+		startup     = pgPacket(pgProtocolVersion3, startupBody)
+	)
+	Context("a client sends a startup packet", func() {
+		It("should parse user and database", func() {
+			rw := bytes.NewBuffer(startup)
+			psm, err := readPgStartupMessage(rw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(psm.User()).To(Equal("app"))
+			Expect(psm.Database()).To(Equal("appdb"))
+			Expect(psm.Raw).To(Equal(startup))
+		})
+		It("should default database to user", func() {
+			rw := bytes.NewBuffer(pgPacket(pgProtocolVersion3, []byte("user\x00app\x00\x00")))
+			psm, err := readPgStartupMessage(rw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(psm.Database()).To(Equal("app"))
+		})
+	})
+	Context("a client requests SSL first", func() {
+		It("should refuse SSL and read the startup packet", func() {
+			rw := bytes.NewBuffer(append(pgPacket(pgSSLRequestCode, nil), startup...))
+			psm, err := readPgStartupMessage(rw)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(psm.User()).To(Equal("app"))
+			Expect(rw.Bytes()).To(Equal([]byte{pgEncryptionRefused}))
+		})
+	})
+	Context("a client sends an invalid packet", func() {
+		It("should fail on a cancel request", func() {
+			_, err := readPgStartupMessage(bytes.NewBuffer(pgPacket(pgCancelRequestCode, make([]byte, 8))))
+			Expect(err).To(HaveOccurred())
+		})
+		It("should fail on an unterminated body", func() {
+			_, err := readPgStartupMessage(bytes.NewBuffer(pgPacket(pgProtocolVersion3, []byte("user\x00app"))))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("routing rules are defined", func() {
+		rprc := RoutePgRouterConfig{
+			Listen:        ":5433",
+			ReadOnlyUsers: []string{"reporting"},
+			Rules: []RoutePgRouterRule{
+				{Database: "app1|app2", Group: "cluster1"},
+				{User: "batch_.*", Group: "cluster2"},
+			},
+		}
+		BeforeEach(func() {
+			Expect(rprc.Validate(RouteConfig{Groups: RouteHostGroups{"cluster1": {"host1"}, "cluster2": {"host2"}}})).
+				To(Succeed())
+		})
+		It("should route by database", func() {
+			group, role, err := rprc.Route("app2", "reporting")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group).To(Equal("cluster1"))
+			Expect(role).To(Equal(proxyRoleStandby))
+		})
+		It("should route by user", func() {
+			group, role, err := rprc.Route("app3", "batch_1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group).To(Equal("cluster2"))
+			Expect(role).To(Equal(proxyRolePrimary))
+		})
+		It("should not route partial matches", func() {
+			_, _, err := rprc.Route("app10", "app")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
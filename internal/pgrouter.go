@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"net"
+	"time"
+)
+
+/*
+ * This module implements a PostgreSQL protocol aware router.
+ * It reads the startup packet of a client, selects a group and role from the requested database and user,
+ * and then splices the connection to the selected node like the TCP proxy does.
+ */
+
+const pgStartupTimeout = 10 * time.Second
+
+// pgRouter is a listener which routes connections to one of multiple groups
+type pgRouter struct {
+	config   RoutePgRouterConfig
+	handler  *PgRouteHandler
+	sessions *proxySessions
+	balancer roundRobin
+}
+
+func newPgRouter(config RoutePgRouterConfig, handler *PgRouteHandler) *pgRouter {
+	return &pgRouter{
+		config:   config,
+		handler:  handler,
+		sessions: newProxySessions(),
+	}
+}
+
+// monitor periodically closes all sessions to nodes which no longer have the role they were routed for
+func (pr *pgRouter) monitor() {
+	ticker := time.NewTicker(pr.config.CheckInterval())
+	defer ticker.Stop()
+
+	for range ticker.C {
+		pr.sessions.closeStale(pr.handler, pr.config.Listen)
+	}
+}
+
+// refuse sends an error to the client and closes the connection
+func (pr *pgRouter) refuse(client net.Conn, message string) {
+	pr.handler.log.Infof("router %s refused connection from %s: %s", pr.config.Listen, client.RemoteAddr(), message)
+	_, _ = client.Write(pgFatalMessage("pgroute66: " + message))
+	_ = client.Close()
+}
+
+func (pr *pgRouter) handle(client net.Conn) {
+	_ = client.SetDeadline(time.Now().Add(pgStartupTimeout))
+
+	startup, err := readPgStartupMessage(client)
+	if err != nil {
+		pr.handler.log.Infof("router %s could not read startup packet from %s: %s", pr.config.Listen,
+			client.RemoteAddr(), err.Error())
+		_ = client.Close()
+
+		return
+	}
+
+	_ = client.SetDeadline(time.Time{})
+
+	group, role, err := pr.config.Route(startup.Database(), startup.User())
+	if err != nil {
+		pr.refuse(client, err.Error())
+
+		return
+	}
+
+	target, err := pr.balancer.pick(pr.handler.roleTargets(group, role))
+	if err != nil {
+		pr.refuse(client, "no "+role+" available for group "+group)
+
+		return
+	}
+
+	backend, err := pr.handler.dialNode(target)
+	if err != nil {
+		pr.handler.log.Errorf("router %s could not connect to %s: %s", pr.config.Listen, target, err.Error())
+		pr.refuse(client, "could not connect to "+target)
+
+		return
+	}
+
+	if _, err = backend.Write(startup.Raw); err != nil {
+		pr.handler.log.Errorf("router %s could not forward startup packet to %s: %s", pr.config.Listen, target,
+			err.Error())
+		_ = backend.Close()
+		pr.refuse(client, "could not connect to "+target)
+
+		return
+	}
+
+	pr.handler.log.Debugf("router %s forwards %s (database %s, user %s) to %s of group %s (%s)", pr.config.Listen,
+		client.RemoteAddr(), startup.Database(), startup.User(), role, group, target)
+	pr.sessions.splice(&proxySession{client: client, backend: backend, group: group, role: role, target: target})
+}
+
+// Serve accepts client connections and routes them
+func (pr *pgRouter) Serve(listener net.Listener) {
+	go pr.monitor()
+
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			pr.handler.log.Errorf("router %s stopped accepting connections: %s", pr.config.Listen, err.Error())
+
+			return
+		}
+
+		go pr.handle(client)
+	}
+}
+
+// RunPgRouters starts a listener for every PostgreSQL router defined in config
+func (prh *PgRouteHandler) RunPgRouters() {
+	for _, routerConfig := range prh.config.Routers {
+		// validating compiles the rules of this copy of the router config, which is passed on to the router
+		if err := routerConfig.Validate(prh.config); err != nil {
+			prh.log.Fatal("Invalid router config", err)
+		}
+
+		listener, err := net.Listen("tcp", routerConfig.Listen)
+		if err != nil {
+			prh.log.Fatalf("Could not listen on %s: %s", routerConfig.Listen, err.Error())
+		}
+
+		prh.log.Infof("Routing PostgreSQL connections on %s", routerConfig.Listen)
+
+		go newPgRouter(routerConfig, prh).Serve(listener)
+	}
+}
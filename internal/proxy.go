@@ -0,0 +1,243 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+ * This module implements a TCP proxy which splices client connections to the current primary (or a standby) of a group.
+ * Sessions are closed when the node they are connected to no longer has the role the proxy routes to.
+ */
+
+// proxySession is a client connection which is spliced to a backend node
+type proxySession struct {
+	client  net.Conn
+	backend net.Conn
+	group   string
+	role    string
+	target  string
+	once    sync.Once
+}
+
+func (ps *proxySession) close() {
+	ps.once.Do(func() {
+		_ = ps.client.Close()
+		_ = ps.backend.Close()
+	})
+}
+
+// proxySessions keeps track of all sessions of a listener
+type proxySessions struct {
+	mutex    sync.Mutex
+	sessions map[*proxySession]bool
+}
+
+func newProxySessions() *proxySessions {
+	return &proxySessions{sessions: map[*proxySession]bool{}}
+}
+
+func (pss *proxySessions) add(ps *proxySession) {
+	pss.mutex.Lock()
+	defer pss.mutex.Unlock()
+	pss.sessions[ps] = true
+}
+
+func (pss *proxySessions) remove(ps *proxySession) {
+	pss.mutex.Lock()
+	defer pss.mutex.Unlock()
+	delete(pss.sessions, ps)
+}
+
+// closeInvalid closes all sessions for which valid returns false, and returns the number of closed sessions
+func (pss *proxySessions) closeInvalid(valid func(ps *proxySession) bool) (closed int) {
+	pss.mutex.Lock()
+	sessions := make([]*proxySession, 0, len(pss.sessions))
+	for ps := range pss.sessions {
+		sessions = append(sessions, ps)
+	}
+	pss.mutex.Unlock()
+
+	// valid might query PostgreSQL, so it is called without holding the lock
+	for _, ps := range sessions {
+		if !valid(ps) {
+			ps.close()
+			pss.remove(ps)
+			closed++
+		}
+	}
+
+	return closed
+}
+
+// splice copies data between client and backend until one of both sides closes
+func (pss *proxySessions) splice(ps *proxySession) {
+	pss.add(ps)
+	defer pss.remove(ps)
+
+	done := make(chan struct{}, 2)
+
+	go func() {
+		_, _ = io.Copy(ps.backend, ps.client)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(ps.client, ps.backend)
+		done <- struct{}{}
+	}()
+	<-done
+	ps.close()
+	<-done
+}
+
+// closeStale closes all sessions to nodes which no longer have the role of their session
+func (pss *proxySessions) closeStale(prh *PgRouteHandler, listen string) {
+	targets := map[string][]string{}
+
+	if closed := pss.closeInvalid(func(ps *proxySession) bool {
+		key := ps.role + "@" + ps.group
+		current, checked := targets[key]
+		if !checked {
+			current = prh.roleTargets(ps.group, ps.role)
+			targets[key] = current
+		}
+
+		return slices.Contains(current, ps.target)
+	}); closed > 0 {
+		prh.log.Infof("listener %s closed %d sessions, as current targets changed to %v", listen, closed, targets)
+	}
+}
+
+// roleTargets returns the names of all nodes of a group that have a specific role.
+// For the primary role, no nodes are returned when there is no primary, or during split brain.
+// For the standby role, standbys tagged noloadbalance are not returned.
+func (prh PgRouteHandler) roleTargets(group string, role string) []string {
+	if role == proxyRoleStandby {
+		return prh.loadBalancedStandbys(group)
+	}
+
+	primaries := prh.GetPrimaries(group)
+	if len(primaries) != 1 {
+		return nil
+	}
+
+	return primaries
+}
+
+// roundRobin picks one of a list of targets, every call picking the next one
+type roundRobin struct {
+	mutex sync.Mutex
+	next  int
+}
+
+func (rr *roundRobin) pick(targets []string) (string, error) {
+	if len(targets) == 0 {
+		return "", errors.New("no node available")
+	}
+
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	rr.next = (rr.next + 1) % len(targets)
+
+	return targets[rr.next], nil
+}
+
+// routeProxy is a listener which forwards to the nodes of a group with a specific role
+type routeProxy struct {
+	config   RouteProxyConfig
+	handler  *PgRouteHandler
+	sessions *proxySessions
+	balancer roundRobin
+}
+
+func newRouteProxy(config RouteProxyConfig, handler *PgRouteHandler) *routeProxy {
+	return &routeProxy{
+		config:   config,
+		handler:  handler,
+		sessions: newProxySessions(),
+	}
+}
+
+// monitor periodically closes all sessions to nodes which no longer have the role this proxy routes to
+func (rp *routeProxy) monitor() {
+	ticker := time.NewTicker(rp.config.CheckInterval())
+	defer ticker.Stop()
+
+	for range ticker.C {
+		rp.sessions.closeStale(rp.handler, rp.config.Listen)
+	}
+}
+
+// dialNode connects to the PostgreSQL server of a node, giving up after proxyDialTimeout
+func (prh PgRouteHandler) dialNode(name string) (net.Conn, error) {
+	conn, exists := prh.connections[name]
+	if !exists {
+		return nil, errors.New("node " + name + " is not defined")
+	}
+
+	return net.DialTimeout("tcp", net.JoinHostPort(conn.Host(), conn.Port()), proxyDialTimeout)
+}
+
+func (rp *routeProxy) handle(client net.Conn) {
+	group, role := rp.config.GroupName(), rp.config.RoleName()
+
+	target, err := rp.balancer.pick(rp.handler.roleTargets(group, role))
+	if err != nil {
+		rp.handler.log.Infof("proxy %s cannot forward connection from %s: %s",
+			rp.config.Listen, client.RemoteAddr(), err.Error())
+		_ = client.Close()
+
+		return
+	}
+
+	backend, err := rp.handler.dialNode(target)
+	if err != nil {
+		rp.handler.log.Errorf("proxy %s could not connect to %s: %s", rp.config.Listen, target, err.Error())
+		_ = client.Close()
+
+		return
+	}
+
+	rp.handler.log.Debugf("proxy %s forwards %s to %s", rp.config.Listen, client.RemoteAddr(), target)
+	rp.sessions.splice(&proxySession{client: client, backend: backend, group: group, role: role, target: target})
+}
+
+// Serve accepts client connections and forwards them
+func (rp *routeProxy) Serve(listener net.Listener) {
+	go rp.monitor()
+
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			rp.handler.log.Errorf("proxy %s stopped accepting connections: %s", rp.config.Listen, err.Error())
+
+			return
+		}
+
+		go rp.handle(client)
+	}
+}
+
+// RunProxies starts a listener for every proxy defined in config
+func (prh *PgRouteHandler) RunProxies() {
+	for _, proxyConfig := range prh.config.Proxies {
+		if err := proxyConfig.Validate(); err != nil {
+			prh.log.Fatal("Invalid proxy config", err)
+		}
+
+		listener, err := net.Listen("tcp", proxyConfig.Listen)
+		if err != nil {
+			prh.log.Fatalf("Could not listen on %s: %s", proxyConfig.Listen, err.Error())
+		}
+
+		prh.log.Infof("Proxying %s to %s of group %s", proxyConfig.Listen, proxyConfig.RoleName(),
+			proxyConfig.GroupName())
+
+		go newRouteProxy(proxyConfig, prh).Serve(listener)
+	}
+}
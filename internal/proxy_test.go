@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Proxy", func() {
+	Context("round robin", func() {
+		It("should pick every target in turn", func() {
+			rr := roundRobin{}
+			targets := []string{"host1", "host2", "host3"}
+
+			var picked []string
+			for range 6 {
+				target, err := rr.pick(targets)
+				Expect(err).NotTo(HaveOccurred())
+				picked = append(picked, target)
+			}
+
+			Expect(picked).To(Equal([]string{"host2", "host3", "host1", "host2", "host3", "host1"}))
+		})
+		It("should fail without targets", func() {
+			_, err := (&roundRobin{}).pick(nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("sessions", func() {
+		newSession := func(target string) (*proxySession, net.Conn) {
+			client, clientPeer := net.Pipe()
+			backend, _ := net.Pipe()
+
+			return &proxySession{client: client, backend: backend, target: target}, clientPeer
+		}
+		It("should only close invalid sessions", func() {
+			sessions := newProxySessions()
+			valid, _ := newSession("host1")
+			invalid, invalidPeer := newSession("host2")
+			sessions.add(valid)
+			sessions.add(invalid)
+
+			closed := sessions.closeInvalid(func(ps *proxySession) bool { return ps.target == "host1" })
+			Expect(closed).To(Equal(1))
+			Expect(sessions.sessions).To(Equal(map[*proxySession]bool{valid: true}))
+
+			// the client side of the closed session is closed
+			_, err := invalidPeer.Read(make([]byte, 1))
+			Expect(err).To(HaveOccurred())
+			valid.close()
+		})
+	})
+	Context("dial", func() {
+		It("should refuse undefined nodes", func() {
+			_, err := PgRouteHandler{}.dialNode("host1")
+			Expect(err).To(MatchError("node host1 is not defined"))
+		})
+	})
+})
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+ * This module renders API responses in the format requested by the client.
+ * The response type is negotiated from the Accept header (json by default, plain text, or yaml),
+ * and node answers can be formatted as node name, host:port, or dsn with the format query parameter.
+ */
+
+const (
+	nodeFormatName     = "name"
+	nodeFormatHostPort = "hostport"
+	nodeFormatDSN      = "dsn"
+)
+
+// plainText returns a plain text representation of a response, with one line for every item of a list
+func plainText(data any) string {
+	switch value := data.(type) {
+	case string:
+		return value
+	case []string:
+		return strings.Join(value, "\n")
+	}
+
+	if marshalled, err := json.Marshal(data); err == nil {
+		return string(marshalled)
+	}
+
+	return fmt.Sprint(data)
+}
+
+// render responds with data, negotiating the content type from the Accept header
+func render(c *gin.Context, code int, data any) {
+	switch c.NegotiateFormat(gin.MIMEJSON, gin.MIMEPlain, gin.MIMEYAML, gin.MIMEYAML2) {
+	case gin.MIMEPlain:
+		c.String(code, plainText(data))
+	case gin.MIMEYAML, gin.MIMEYAML2:
+		c.YAML(code, data)
+	default:
+		c.IndentedJSON(code, data)
+	}
+}
+
+// FormatNode returns a node as its name, as host:port, or as a dsn (without secrets)
+func (prh PgRouteHandler) FormatNode(name string, format string) (string, error) {
+	conn, exists := prh.connections[name]
+	if !exists {
+		return "", fmt.Errorf("node %s is not defined", name)
+	}
+
+	switch format {
+	case nodeFormatName:
+		return name, nil
+	case nodeFormatHostPort:
+		return net.JoinHostPort(conn.Host(), conn.Port()), nil
+	case nodeFormatDSN:
+		dsn := conn.PublicParams()
+		dsn[paramHost], dsn[paramPort] = conn.Host(), conn.Port()
+
+		return dsn.String(), nil
+	}
+
+	return "", fmt.Errorf("invalid format %s (should be one of %s, %s, or %s)", format, nodeFormatName,
+		nodeFormatHostPort, nodeFormatDSN)
+}
+
+// nodeFormat returns the node format requested with the format query parameter.
+// When the format is invalid, a bad request is sent, and ok is false.
+func nodeFormat(c *gin.Context) (format string, ok bool) {
+	format = c.DefaultQuery("format", nodeFormatName)
+	switch format {
+	case nodeFormatName, nodeFormatHostPort, nodeFormatDSN:
+		return format, true
+	}
+
+	render(c, http.StatusBadRequest, fmt.Sprintf("invalid format %s (should be one of %s, %s, or %s)", format,
+		nodeFormatName, nodeFormatHostPort, nodeFormatDSN))
+
+	return "", false
+}
+
+// renderNodes responds with a list of nodes, formatted as requested with the format query parameter
+func renderNodes(c *gin.Context, code int, names []string) {
+	format, ok := nodeFormat(c)
+	if !ok {
+		return
+	}
+
+	// formatted is kept nil for an empty list, which renders as null, just like unformatted lists
+	var formatted []string
+
+	for _, name := range names {
+		node, err := globalHandler.FormatNode(name, format)
+		if err != nil {
+			render(c, http.StatusInternalServerError, err.Error())
+
+			return
+		}
+
+		formatted = append(formatted, node)
+	}
+
+	render(c, code, formatted)
+}
+
+// renderNode responds with one node, formatted as requested with the format query parameter
+func renderNode(c *gin.Context, code int, name string) {
+	format, ok := nodeFormat(c)
+	if !ok {
+		return
+	}
+
+	node, err := globalHandler.FormatNode(name, format)
+	if err != nil {
+		render(c, http.StatusInternalServerError, err.Error())
+
+		return
+	}
+
+	render(c, code, node)
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func renderWithAccept(accept string, data any) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if accept != "" {
+		c.Request.Header.Set("Accept", accept)
+	}
+
+	render(c, http.StatusOK, data)
+
+	return recorder
+}
+
+var _ = Describe("Render", func() {
+	nodes := []string{"host1", "host2"}
+	Context("a client does not specify a content type", func() {
+		It("should respond with indented json", func() {
+			Expect(renderWithAccept("", nodes).Body.String()).To(Equal("[\n    \"host1\",\n    \"host2\"\n]"))
+			Expect(renderWithAccept("*/*", "host1").Body.String()).To(Equal("\"host1\""))
+		})
+	})
+	Context("a client accepts plain text", func() {
+		It("should respond with one line per node", func() {
+			Expect(renderWithAccept(gin.MIMEPlain, nodes).Body.String()).To(Equal("host1\nhost2"))
+			Expect(renderWithAccept(gin.MIMEPlain, "host1").Body.String()).To(Equal("host1"))
+		})
+	})
+	Context("a client accepts yaml", func() {
+		It("should respond with yaml", func() {
+			Expect(renderWithAccept(gin.MIMEYAML2, nodes).Body.String()).To(Equal("- host1\n- host2\n"))
+		})
+	})
+})
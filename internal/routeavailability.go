@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+)
+
+/*
+ * This module aggregates the availability (the time since the last heartbeat) of all members of a group.
+ */
+
+// RouteNodeAvailability is the availability of one node, as an outcome of the v2 api (ok, lag_exceeded, ...)
+type RouteNodeAvailability struct {
+	Status  string `json:"status" yaml:"status"`
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+// RouteGroupAvailability is the availability of all members of a group
+type RouteGroupAvailability struct {
+	Name string `json:"name" yaml:"name"`
+	// Available is the number of members that are within the limit
+	Available int                              `json:"available" yaml:"available"`
+	Total     int                              `json:"total" yaml:"total"`
+	Nodes     map[string]RouteNodeAvailability `json:"nodes" yaml:"nodes"`
+}
+
+// availabilityOutcome returns the outcome of an availability check
+func availabilityOutcome(err error) string {
+	var exceeded pg.AvcDurationExceededError
+
+	switch {
+	case err == nil:
+		return outcomeOk
+	case errors.Is(err, errUndefinedNode):
+		return outcomeInvalidNode
+	case errors.As(err, &exceeded):
+		return outcomeLagExceeded
+	case errors.Is(err, pg.ErrAvcPermissionDenied):
+		return outcomePermissionDenied
+	case errors.Is(err, pg.ErrAvcTableMissing), errors.Is(err, pg.ErrAvcNoHeartbeat):
+		return outcomeHeartbeatMissing
+	default:
+		return outcomeCheckFailed
+	}
+}
+
+// GetGroupAvailability checks the availability of all members of a group
+func (prh PgRouteHandler) GetGroupAvailability(group string, limit time.Duration) (RouteGroupAvailability, error) {
+	if !prh.config.HasGroup(group) {
+		return RouteGroupAvailability{}, fmt.Errorf("group %s is not defined", group)
+	}
+
+	availability := RouteGroupAvailability{Name: group, Nodes: map[string]RouteNodeAvailability{}}
+
+	for name := range prh.connections.FilteredConnections(prh.config.GroupHosts(group)) {
+		err := prh.CheckNodeAvailability(name, limit)
+
+		node := RouteNodeAvailability{Status: availabilityOutcome(err)}
+		if err != nil {
+			node.Message = err.Error()
+		} else {
+			availability.Available++
+		}
+
+		availability.Nodes[name] = node
+		availability.Total++
+	}
+
+	return availability, nil
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+ * This module caches state of groups that is expensive to read (like timelines), as it is checked for every
+ * routing answer. Entries expire after cache_interval, and all entries are dropped when a node is promoted.
+ * The same cache holds state per node (like resolved addresses), keyed by node name.
+ */
+
+const defaultCacheInterval = 5 * time.Second
+
+// routeGroupCache holds a value for every group, with the time it was read
+type routeGroupCache[T any] struct {
+	mutex  sync.Mutex
+	groups map[string]routeCacheEntry[T]
+}
+
+type routeCacheEntry[T any] struct {
+	at    time.Time
+	value T
+}
+
+func newRouteGroupCache[T any]() *routeGroupCache[T] {
+	return &routeGroupCache[T]{groups: map[string]routeCacheEntry[T]{}}
+}
+
+// get returns the value of a group, when it was read less than maxAge ago
+func (rgc *routeGroupCache[T]) get(group string, maxAge time.Duration) (value T, cached bool) {
+	rgc.mutex.Lock()
+	defer rgc.mutex.Unlock()
+
+	entry, exists := rgc.groups[group]
+	if !exists || time.Since(entry.at) >= maxAge {
+		return value, false
+	}
+
+	return entry.value, true
+}
+
+func (rgc *routeGroupCache[T]) set(group string, value T) {
+	rgc.mutex.Lock()
+	defer rgc.mutex.Unlock()
+
+	rgc.groups[group] = routeCacheEntry[T]{at: time.Now(), value: value}
+}
+
+// reset drops the values of all groups
+func (rgc *routeGroupCache[T]) reset() {
+	rgc.mutex.Lock()
+	defer rgc.mutex.Unlock()
+
+	rgc.groups = map[string]routeCacheEntry[T]{}
+}
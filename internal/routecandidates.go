@@ -0,0 +1,190 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+ * This module ranks the standbys of a group as candidates for promotion, with the reason for every rank.
+ * Candidates are ranked by eligibility (not tagged nofailover, not diverged from the primary, and with a known state),
+ * timeline, received WAL, configured priority, replay-paused state, replayed WAL and finally by name.
+ * Automatic failover promotes the first eligible candidate, so this ranking also decides failover.
+ */
+
+// RouteCandidate is a standby of a group, ranked as candidate for promotion
+type RouteCandidate struct {
+	Name string `json:"name" yaml:"name"`
+	// Rank is the position of the candidate, starting at 1 for the best candidate
+	Rank         int    `json:"rank" yaml:"rank"`
+	Eligible     bool   `json:"eligible" yaml:"eligible"`
+	Timeline     int64  `json:"timeline" yaml:"timeline"`
+	ReceivedLSN  int64  `json:"received_lsn" yaml:"received_lsn"`
+	ReplayedLSN  int64  `json:"replayed_lsn" yaml:"replayed_lsn"`
+	Priority     int    `json:"priority" yaml:"priority"`
+	NoFailover   bool   `json:"nofailover" yaml:"nofailover"`
+	ReplayPaused bool   `json:"replay_paused" yaml:"replay_paused"`
+	NeedsRebuild bool   `json:"needs_rebuild" yaml:"needs_rebuild"`
+	Reason       string `json:"reason" yaml:"reason"`
+	// Error is why the state of the candidate could not be read, which makes it ineligible
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// ineligibleReason returns why a candidate can not be promoted
+func (rc RouteCandidate) ineligibleReason() string {
+	var reasons []string
+	if rc.NoFailover {
+		reasons = append(reasons, "tagged nofailover")
+	}
+
+	if rc.NeedsRebuild {
+		reasons = append(reasons, "needs to be rebuilt")
+	}
+
+	if rc.Error != "" {
+		reasons = append(reasons, "state unknown ("+rc.Error+")")
+	}
+
+	return "not eligible: " + strings.Join(reasons, ", ")
+}
+
+// compareBool orders true before false
+func compareBool(a bool, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// compareCandidates returns a negative number when a ranks before b, and a positive number otherwise
+func compareCandidates(a RouteCandidate, b RouteCandidate) int {
+	for _, result := range []int{
+		compareBool(a.Eligible, b.Eligible),
+		cmp.Compare(b.Timeline, a.Timeline),
+		cmp.Compare(b.ReceivedLSN, a.ReceivedLSN),
+		cmp.Compare(b.Priority, a.Priority),
+		compareBool(!a.ReplayPaused, !b.ReplayPaused),
+		cmp.Compare(b.ReplayedLSN, a.ReplayedLSN),
+	} {
+		if result != 0 {
+			return result
+		}
+	}
+
+	return strings.Compare(a.Name, b.Name)
+}
+
+// rankReason returns why an eligible candidate ranks after the candidate before it
+func rankReason(before RouteCandidate, after RouteCandidate) string {
+	switch {
+	case before.Timeline != after.Timeline:
+		return fmt.Sprintf("on timeline %d, behind timeline %d of %s", after.Timeline, before.Timeline, before.Name)
+	case before.ReceivedLSN != after.ReceivedLSN:
+		return fmt.Sprintf("received %d bytes less than %s", before.ReceivedLSN-after.ReceivedLSN, before.Name)
+	case before.Priority != after.Priority:
+		return fmt.Sprintf("priority %d is lower than priority %d of %s", after.Priority, before.Priority,
+			before.Name)
+	case before.ReplayPaused != after.ReplayPaused:
+		return fmt.Sprintf("replay is paused, and not on %s", before.Name)
+	case before.ReplayedLSN != after.ReplayedLSN:
+		return fmt.Sprintf("replayed %d bytes less than %s", before.ReplayedLSN-after.ReplayedLSN, before.Name)
+	default:
+		return fmt.Sprintf("equal to %s, which sorts first by name", before.Name)
+	}
+}
+
+// rankCandidates sorts candidates, the best candidate first, and sets the rank and reason of every candidate
+func rankCandidates(candidates []RouteCandidate) {
+	slices.SortStableFunc(candidates, compareCandidates)
+
+	for i := range candidates {
+		candidates[i].Rank = i + 1
+
+		switch {
+		case !candidates[i].Eligible:
+			candidates[i].Reason = candidates[i].ineligibleReason()
+		case i == 0:
+			candidates[i].Reason = "best candidate"
+		default:
+			candidates[i].Reason = rankReason(candidates[i-1], candidates[i])
+		}
+	}
+}
+
+// candidate returns a standby as candidate for promotion
+func (prh PgRouteHandler) candidate(name string, rebuild []string) (candidate RouteCandidate, err error) {
+	conn := prh.connections[name]
+	candidate = RouteCandidate{
+		Name:         name,
+		Priority:     prh.config.Hosts[name].Priority,
+		NoFailover:   prh.config.Hosts[name].Tags.Has(tagNoFailover),
+		NeedsRebuild: slices.Contains(rebuild, name),
+	}
+	candidate.Eligible = !candidate.NoFailover && !candidate.NeedsRebuild
+
+	if candidate.Timeline, err = conn.Timeline(context.Background()); err != nil {
+		return candidate, err
+	}
+
+	if candidate.ReplayedLSN, err = conn.ReplayLSN(context.Background()); err != nil {
+		return candidate, err
+	}
+
+	candidate.ReceivedLSN = candidate.ReplayedLSN
+
+	receiver, exists, err := conn.WalReceiver(context.Background())
+	if err != nil {
+		return candidate, err
+	} else if exists && receiver.FlushedLSN > candidate.ReceivedLSN {
+		candidate.ReceivedLSN = receiver.FlushedLSN
+	}
+
+	candidate.ReplayPaused, err = conn.ReplayPaused(context.Background())
+
+	return candidate, err
+}
+
+// rankedCandidates returns standbys of a group as candidates for promotion, the best candidate first
+func (prh PgRouteHandler) rankedCandidates(group string, standbys []string) []RouteCandidate {
+	rebuild := prh.needsRebuild(group)
+	candidates := []RouteCandidate{}
+
+	for _, name := range standbys {
+		candidate, err := prh.candidate(name, rebuild)
+		if err != nil {
+			prh.log.Debugf("Could not get state of candidate %s, %s", name, err.Error())
+			candidate.Eligible, candidate.Error = false, err.Error()
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	rankCandidates(candidates)
+
+	return candidates
+}
+
+// bestCandidate returns the first of ranked candidates, when it is eligible for promotion
+func bestCandidate(candidates []RouteCandidate) (RouteCandidate, bool) {
+	if len(candidates) == 0 || !candidates[0].Eligible {
+		return RouteCandidate{}, false
+	}
+
+	return candidates[0], true
+}
+
+// GetCandidates returns all standbys of a group (including orphaned standbys), ranked as candidates for promotion
+func (prh PgRouteHandler) GetCandidates(group string) ([]RouteCandidate, error) {
+	if !prh.config.HasGroup(group) {
+		return nil, fmt.Errorf("group %s is not defined", group)
+	}
+
+	return prh.rankedCandidates(group, prh.groupStandbys(group)), nil
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routecandidates", func() {
+	names := func(candidates []RouteCandidate) (names []string) {
+		for _, candidate := range candidates {
+			names = append(names, candidate.Name)
+		}
+
+		return names
+	}
+	Context("ranking", func() {
+		It("should prefer most WAL, then priority, then name", func() {
+			candidates := []RouteCandidate{
+				{Name: "host4", Eligible: true, ReceivedLSN: 100, Priority: 1},
+				{Name: "host3", Eligible: true, ReceivedLSN: 200},
+				{Name: "host2", Eligible: true, ReceivedLSN: 200, Priority: 2},
+				{Name: "host1", Eligible: true, ReceivedLSN: 100, Priority: 1},
+			}
+			rankCandidates(candidates)
+			Expect(names(candidates)).To(Equal([]string{"host2", "host3", "host1", "host4"}))
+			Expect(candidates[0].Rank).To(Equal(1))
+			Expect(candidates[0].Reason).To(Equal("best candidate"))
+			Expect(candidates[1].Reason).To(Equal("priority 0 is lower than priority 2 of host2"))
+			Expect(candidates[2].Reason).To(Equal("received 100 bytes less than host3"))
+			Expect(candidates[3].Reason).To(Equal("equal to host1, which sorts first by name"))
+		})
+		It("should rank ineligible candidates last, and newer timelines first", func() {
+			candidates := []RouteCandidate{
+				{Name: "host1", Eligible: false, NoFailover: true, Timeline: 3, ReceivedLSN: 300},
+				{Name: "host2", Eligible: true, Timeline: 2, ReceivedLSN: 400},
+				{Name: "host3", Eligible: true, Timeline: 3, ReceivedLSN: 200},
+			}
+			rankCandidates(candidates)
+			Expect(names(candidates)).To(Equal([]string{"host3", "host2", "host1"}))
+			Expect(candidates[1].Reason).To(Equal("on timeline 2, behind timeline 3 of host3"))
+			Expect(candidates[2].Reason).To(Equal("not eligible: tagged nofailover"))
+		})
+		It("should rank paused replay after running replay", func() {
+			candidates := []RouteCandidate{
+				{Name: "host1", Eligible: true, ReceivedLSN: 300, ReplayedLSN: 300, ReplayPaused: true},
+				{Name: "host2", Eligible: true, ReceivedLSN: 300, ReplayedLSN: 100},
+			}
+			rankCandidates(candidates)
+			Expect(names(candidates)).To(Equal([]string{"host2", "host1"}))
+			Expect(candidates[1].Reason).To(Equal("replay is paused, and not on host2"))
+		})
+		It("should list candidates with unknown state as ineligible, with the error", func() {
+			candidates := []RouteCandidate{
+				{Name: "host1", Error: "connection refused"},
+				{Name: "host2", Eligible: true, ReceivedLSN: 100},
+			}
+			rankCandidates(candidates)
+			Expect(names(candidates)).To(Equal([]string{"host2", "host1"}))
+			Expect(candidates[1].Reason).To(Equal("not eligible: state unknown (connection refused)"))
+		})
+	})
+})
@@ -0,0 +1,34 @@
+package internal
+
+import "time"
+
+const (
+	defaultClockSkewWarn     = 500 * time.Millisecond
+	defaultClockSkewInterval = 10 * time.Second
+)
+
+// RouteClockSkewConfig defines how often clock skew between pgroute66 and the nodes is measured,
+// when to warn for it, and whether to correct availability results for it
+type RouteClockSkewConfig struct {
+	Warn     time.Duration `yaml:"warn"`
+	Correct  bool          `yaml:"correct"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// WarnThreshold returns the clock skew above which a warning is logged
+func (rcsc RouteClockSkewConfig) WarnThreshold() time.Duration {
+	if rcsc.Warn <= 0 {
+		return defaultClockSkewWarn
+	}
+
+	return rcsc.Warn
+}
+
+// MeasureInterval returns the interval at which the clock skew of all nodes is measured
+func (rcsc RouteClockSkewConfig) MeasureInterval() time.Duration {
+	if rcsc.Interval <= 0 {
+		return defaultClockSkewInterval
+	}
+
+	return rcsc.Interval
+}
@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routeclockskewconfig", func() {
+	Context("warn threshold", func() {
+		It("should default to 500ms", func() {
+			Expect(RouteClockSkewConfig{}.WarnThreshold()).To(Equal(500 * time.Millisecond))
+		})
+		It("should use the configured threshold", func() {
+			Expect(RouteClockSkewConfig{Warn: time.Second}.WarnThreshold()).To(Equal(time.Second))
+		})
+	})
+	Context("measure interval", func() {
+		It("should default to 10s", func() {
+			Expect(RouteClockSkewConfig{}.MeasureInterval()).To(Equal(10 * time.Second))
+			Expect(RouteClockSkewConfig{Interval: time.Minute}.MeasureInterval()).To(Equal(time.Minute))
+		})
+	})
+	Context("measured clock skew", func() {
+		It("should report the last measured skew of the members of a group", func() {
+			handler := newTestHandler(RouteConfig{Groups: RouteHostGroups{"cluster": {"host1", "host2", "host3"}}})
+			handler.clockSkews.set("host1", time.Second)
+			handler.clockSkews.set("host2", -500*time.Millisecond)
+			handler.clockSkews.set("host4", time.Minute)
+			Expect(handler.GetClockSkews("cluster")).To(Equal(map[string]float64{"host1": 1, "host2": -0.5}))
+			handler.clockSkews.remove("host2")
+			Expect(handler.GetClockSkews("cluster")).To(Equal(map[string]float64{"host1": 1}))
+		})
+	})
+})
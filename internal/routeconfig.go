@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -22,13 +25,40 @@ const (
 
 // RouteConfig defines all config for the api
 type RouteConfig struct {
-	Hosts    RouteHostsConfig `yaml:"hosts"`
-	Groups   RouteHostGroups  `yaml:"groups"`
-	Bind     string           `yaml:"bind"`
-	Port     int              `yaml:"port"`
-	Ssl      RouteSSLConfig   `yaml:"ssl"`
-	LogLevel string           `yaml:"loglevel"`
-	LogFile  string           `yaml:"logfile"`
+	Hosts    RouteHostsConfig      `yaml:"hosts"`
+	Groups   RouteHostGroups       `yaml:"groups"`
+	Bind     string                `yaml:"bind"`
+	Port     int                   `yaml:"port"`
+	Ssl      RouteSSLConfig        `yaml:"ssl"`
+	LogLevel string                `yaml:"loglevel"`
+	LogFile  string                `yaml:"logfile"`
+	Proxies  []RouteProxyConfig    `yaml:"proxies"`
+	Routers  []RoutePgRouterConfig `yaml:"routers"`
+	DNS      RouteDNSConfig        `yaml:"dns"`
+	// ClockSkew defines when to warn for clock skew, and whether to correct availability for it
+	ClockSkew RouteClockSkewConfig `yaml:"clock_skew"`
+	// InstanceID identifies this instance of pgroute66 in the heartbeat table (defaults to the hostname)
+	InstanceID string `yaml:"instance_id"`
+	// Heartbeat defines how often the heartbeat for availability checks is written
+	Heartbeat RouteHeartbeatConfig `yaml:"heartbeat"`
+	// Stats defines how availability statistics are sampled and persisted
+	Stats RouteStatsConfig `yaml:"stats"`
+	// AdminToken is the bearer token for routes that change the cluster (like switchover), which are disabled without
+	AdminToken string `yaml:"admin_token"`
+	// Switchover defines the limits of a switchover
+	Switchover RouteSwitchoverConfig `yaml:"switchover"`
+	// Failover defines which groups fail over automatically, and when
+	Failover RouteFailoverConfig `yaml:"failover"`
+	// AutoGroups adds a group for every system identifier of the nodes at startup
+	AutoGroups bool `yaml:"auto_groups"`
+	// ExcludeOrphanedStandbys leaves standbys without streaming WAL receiver out of the standbys
+	ExcludeOrphanedStandbys bool `yaml:"exclude_orphaned_standbys"`
+	// ReadTimelineHistory reads timeline history files from the primary, to exactly detect diverged nodes
+	ReadTimelineHistory bool `yaml:"read_timeline_history"`
+	// CacheInterval is how long state of a group that is checked for every routing answer is cached (defaults to 5s)
+	CacheInterval time.Duration `yaml:"cache_interval"`
+	// StatusCodes maps outcomes of the v2 api to HTTP status codes
+	StatusCodes RouteStatusCodes `yaml:"status_codes"`
 }
 
 // NewConfig initializes and returns a route config
@@ -69,6 +99,8 @@ func NewConfig() (config RouteConfig, err error) {
 
 	if err = yaml.Unmarshal(yamlConfig, &config); err != nil {
 		return RouteConfig{}, err
+	} else if err = config.StatusCodes.Validate(); err != nil {
+		return RouteConfig{}, err
 	} else if debug {
 		config.LogLevel = debugLoglevel
 	} else {
@@ -99,6 +131,52 @@ func (rc RouteConfig) GroupHosts(groupName string) RouteHostGroup {
 	return groupHosts
 }
 
+// NodeGroups returns the names of all groups (sorted) that hold a node
+func (rc RouteConfig) NodeGroups(name string) (groups []string) {
+	for groupName, hosts := range rc.Groups {
+		if slices.Contains(hosts, name) {
+			groups = append(groups, groupName)
+		}
+	}
+
+	sort.Strings(groups)
+
+	return groups
+}
+
+// HasGroup returns true when a group is defined in rc.HostGroups, or is the special placeholder "all"
+func (rc RouteConfig) HasGroup(groupName string) bool {
+	if groupName == "all" {
+		return true
+	}
+
+	_, ok := rc.Groups[groupName]
+
+	return ok
+}
+
+// Instance returns the id of this instance of pgroute66, defaulting to the hostname
+func (rc RouteConfig) Instance() string {
+	if rc.InstanceID != "" {
+		return rc.InstanceID
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+
+	return "localhost"
+}
+
+// StateCacheInterval returns how long state of a group (like timelines) is cached
+func (rc RouteConfig) StateCacheInterval() time.Duration {
+	if rc.CacheInterval <= 0 {
+		return defaultCacheInterval
+	}
+
+	return rc.CacheInterval
+}
+
 // BindTo returns the string of the host/port to bind to
 func (rc RouteConfig) BindTo() string {
 	port := rc.Port
@@ -0,0 +1,17 @@
+package internal
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routeconfig", func() {
+	Context("node groups", func() {
+		config := RouteConfig{Groups: RouteHostGroups{"b": {"host1", "host2"}, "a": {"host1"}}}
+		It("should return the sorted groups of a node", func() {
+			Expect(config.NodeGroups("host1")).To(Equal([]string{"a", "b"}))
+			Expect(config.NodeGroups("host2")).To(Equal([]string{"b"}))
+			Expect(config.NodeGroups("host3")).To(BeEmpty())
+		})
+	})
+})
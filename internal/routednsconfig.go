@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+)
+
+const defaultDNSTTL = 5
+
+// RouteDNSConfig defines the zone pgroute66 serves authoritative DNS for
+type RouteDNSConfig struct {
+	Listen     string `yaml:"listen"`
+	Zone       string `yaml:"zone"`
+	TTL        uint32 `yaml:"ttl"`
+	NameServer string `yaml:"nameserver"`
+}
+
+// Enabled returns whether the DNS server should run
+func (rdc RouteDNSConfig) Enabled() bool {
+	return rdc.Listen != ""
+}
+
+// Validate returns an error when this DNS config cannot be used
+func (rdc RouteDNSConfig) Validate() error {
+	if rdc.Zone == "" {
+		return errors.New("dns is enabled, but no zone is defined")
+	}
+
+	return nil
+}
+
+// ZoneName returns the fully qualified (lowercase, with trailing dot) name of the zone
+func (rdc RouteDNSConfig) ZoneName() string {
+	return strings.ToLower(strings.TrimSuffix(rdc.Zone, ".")) + "."
+}
+
+// RecordTTL returns the TTL of all records, which should be short as answers change on failover
+func (rdc RouteDNSConfig) RecordTTL() uint32 {
+	if rdc.TTL == 0 {
+		return defaultDNSTTL
+	}
+
+	return rdc.TTL
+}
+
+// NameServerName returns the fully qualified name of the name server as reported in the SOA record
+func (rdc RouteDNSConfig) NameServerName() string {
+	if rdc.NameServer == "" {
+		return "ns." + rdc.ZoneName()
+	}
+
+	return strings.TrimSuffix(rdc.NameServer, ".") + "."
+}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultFailoverGrace    = 30 * time.Second
+	defaultFailoverInterval = 5 * time.Second
+)
+
+// RouteFailoverConfig defines which groups fail over automatically, and when
+type RouteFailoverConfig struct {
+	// Groups are the groups that fail over automatically (failover is opt-in per group)
+	Groups []string `yaml:"groups"`
+	// Grace is how long the primary should be unavailable before failing over
+	Grace time.Duration `yaml:"grace"`
+	// Interval is how often the primary of every group is checked
+	Interval time.Duration `yaml:"interval"`
+	// Quorum is the number of standbys that should confirm that they lost the primary (defaults to a majority)
+	Quorum int `yaml:"quorum"`
+}
+
+// FailoverGrace returns how long the primary should be unavailable before failing over
+func (rfc RouteFailoverConfig) FailoverGrace() time.Duration {
+	if rfc.Grace <= 0 {
+		return defaultFailoverGrace
+	}
+
+	return rfc.Grace
+}
+
+// FailoverInterval returns how often the primary of every group is checked
+func (rfc RouteFailoverConfig) FailoverInterval() time.Duration {
+	if rfc.Interval <= 0 {
+		return defaultFailoverInterval
+	}
+
+	return rfc.Interval
+}
+
+// RequiredQuorum returns how many of a number of standbys should confirm that they lost the primary
+func (rfc RouteFailoverConfig) RequiredQuorum(standbys int) int {
+	if rfc.Quorum > 0 {
+		return rfc.Quorum
+	}
+
+	return standbys/2 + 1
+}
+
+// ValidateFailover returns an error when a group that fails over automatically is not defined,
+// or when the quorum can never be met for it
+func (rc RouteConfig) ValidateFailover() error {
+	if rc.Failover.Quorum < 0 {
+		return fmt.Errorf("invalid failover quorum %d (should be 0 for a majority, or more)", rc.Failover.Quorum)
+	}
+
+	for _, group := range rc.Failover.Groups {
+		if !rc.HasGroup(group) {
+			return fmt.Errorf("failover group %s is not defined", group)
+		} else if standbys := len(rc.GroupHosts(group)) - 1; rc.Failover.Quorum > standbys {
+			return fmt.Errorf("failover quorum %d exceeds the %d standbys of group %s", rc.Failover.Quorum,
+				max(standbys, 0), group)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routefailoverconfig", func() {
+	Context("validate", func() {
+		groups := RouteHostGroups{"cluster": {"host1", "host2", "host3"}}
+		It("should accept defined groups with a quorum that can be met", func() {
+			Expect(RouteConfig{}.ValidateFailover()).To(Succeed())
+			Expect(RouteConfig{Groups: groups, Failover: RouteFailoverConfig{Groups: []string{"cluster"}, Quorum: 2}}.
+				ValidateFailover()).To(Succeed())
+		})
+		It("should reject undefined groups", func() {
+			Expect(RouteConfig{Groups: groups, Failover: RouteFailoverConfig{Groups: []string{"other"}}}.
+				ValidateFailover()).To(MatchError("failover group other is not defined"))
+		})
+		It("should reject a quorum that can never be met", func() {
+			Expect(RouteConfig{Groups: groups, Failover: RouteFailoverConfig{Groups: []string{"cluster"}, Quorum: 3}}.
+				ValidateFailover()).To(MatchError("failover quorum 3 exceeds the 2 standbys of group cluster"))
+			Expect(RouteConfig{Failover: RouteFailoverConfig{Quorum: -1}}.ValidateFailover()).NotTo(Succeed())
+		})
+	})
+	Context("defaults", func() {
+		It("should default grace and interval", func() {
+			Expect(RouteFailoverConfig{}.FailoverGrace()).To(Equal(30 * time.Second))
+			Expect(RouteFailoverConfig{}.FailoverInterval()).To(Equal(5 * time.Second))
+		})
+		It("should require a majority of standbys by default", func() {
+			Expect(RouteFailoverConfig{}.RequiredQuorum(0)).To(Equal(1))
+			Expect(RouteFailoverConfig{}.RequiredQuorum(2)).To(Equal(2))
+			Expect(RouteFailoverConfig{}.RequiredQuorum(3)).To(Equal(2))
+			Expect(RouteFailoverConfig{Quorum: 1}.RequiredQuorum(3)).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	groupHealthy    = "healthy"
+	groupDegraded   = "degraded"
+	groupNoPrimary  = "no-primary"
+	groupSplitBrain = "split-brain"
+	// groupMisconfigured means that members are foreign (of another cluster) or duplicate
+	groupMisconfigured = "misconfigured"
+)
+
+// RouteGroupSummary describes the members of a group, their roles, and the health of the group
+type RouteGroupSummary struct {
+	Name        string   `json:"name" yaml:"name"`
+	Members     []string `json:"members" yaml:"members"`
+	Primary     string   `json:"primary" yaml:"primary"`
+	Primaries   []string `json:"primaries" yaml:"primaries"`
+	Standbys    []string `json:"standbys" yaml:"standbys"`
+	Unavailable []string `json:"unavailable" yaml:"unavailable"`
+	Health      string   `json:"health" yaml:"health"`
+	// Fenced holds the primaries that are fenced by a switchover, and left out of routing answers
+	Fenced []string `json:"fenced" yaml:"fenced"`
+	// Maintenance is true when the group is in maintenance, which prevents automatic failover
+	Maintenance bool `json:"maintenance" yaml:"maintenance"`
+	// Replication is the replication state of every member (e.g. standby-orphaned or primary-isolated)
+	Replication map[string]string `json:"replication" yaml:"replication"`
+	// Timelines holds the timeline of every available member
+	Timelines    map[string]int64 `json:"timelines" yaml:"timelines"`
+	NeedsRebuild []string         `json:"needs_rebuild" yaml:"needs_rebuild"`
+	// SystemIdentifiers holds the system identifier of every available member
+	SystemIdentifiers map[string]string `json:"system_identifiers" yaml:"system_identifiers"`
+	// Misconfigured holds the members that are a foreign-cluster or duplicate-node
+	Misconfigured map[string]string `json:"misconfigured" yaml:"misconfigured"`
+	// ClockSkew holds how many seconds the clock of every available member is ahead of the clock of pgroute66
+	ClockSkew map[string]float64 `json:"clock_skew" yaml:"clock_skew"`
+}
+
+// hasReplicationIssues returns whether a member is orphaned, isolated, or follows an unknown upstream
+func (rgs RouteGroupSummary) hasReplicationIssues() bool {
+	for _, state := range rgs.Replication {
+		switch state {
+		case replicationStandbyOrphaned, replicationStandbyUnknownUpstream, replicationPrimaryIsolated:
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetGroups returns the names of all groups, sorted
+func (prh PgRouteHandler) GetGroups() []string {
+	groups := make([]string, 0, len(prh.config.Groups))
+	for name := range prh.config.Groups {
+		groups = append(groups, name)
+	}
+
+	sort.Strings(groups)
+
+	return groups
+}
+
+// GetGroup returns the members, roles and health of a group
+func (prh PgRouteHandler) GetGroup(name string) (summary RouteGroupSummary, err error) {
+	if !prh.config.HasGroup(name) {
+		return summary, fmt.Errorf("group %s is not defined", name)
+	}
+
+	summary = RouteGroupSummary{
+		Name:         name,
+		Members:      []string{},
+		Primaries:    []string{},
+		Standbys:     []string{},
+		Unavailable:  []string{},
+		Fenced:       []string{},
+		Maintenance:  prh.maintenance.contains(name),
+		Replication:  prh.replicationStates(name),
+		Timelines:    map[string]int64{},
+		NeedsRebuild: []string{},
+		ClockSkew:    prh.GetClockSkews(name),
+	}
+
+	summary.SystemIdentifiers, summary.Misconfigured = prh.GetIdentities(name)
+
+	for member, nodeTimeline := range prh.GetTimelines(name) {
+		summary.Timelines[member] = nodeTimeline.Timeline
+		if nodeTimeline.NeedsRebuild {
+			summary.NeedsRebuild = append(summary.NeedsRebuild, member)
+		}
+	}
+
+	for member := range prh.connections.FilteredConnections(prh.config.GroupHosts(name)) {
+		summary.Members = append(summary.Members, member)
+
+		switch status := prh.GetNodeStatus(member); {
+		case status == ghStatusPrimary && prh.isFencedPrimary(member):
+			summary.Fenced = append(summary.Fenced, member)
+		case status == ghStatusPrimary:
+			summary.Primaries = append(summary.Primaries, member)
+		case status == ghStatusStandby:
+			summary.Standbys = append(summary.Standbys, member)
+		default:
+			summary.Unavailable = append(summary.Unavailable, member)
+		}
+	}
+
+	for _, list := range [][]string{summary.Members, summary.Primaries, summary.Standbys, summary.Unavailable,
+		summary.Fenced, summary.NeedsRebuild} {
+		sort.Strings(list)
+	}
+
+	if len(summary.Primaries) == 1 {
+		summary.Primary = summary.Primaries[0]
+	}
+
+	switch {
+	case len(summary.Misconfigured) > 0:
+		summary.Health = groupMisconfigured
+	case len(summary.Primaries) > 1:
+		summary.Health = groupSplitBrain
+	case len(summary.Primaries) == 0:
+		summary.Health = groupNoPrimary
+	case len(summary.Unavailable) > 0 || len(summary.Fenced) > 0 || len(summary.NeedsRebuild) > 0 ||
+		summary.hasReplicationIssues():
+		summary.Health = groupDegraded
+	default:
+		summary.Health = groupHealthy
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+)
+
+const (
+	defaultHeartbeatInterval = time.Second
+	// heartbeatModeCreate writes the heartbeat, creating the table when it does not exist
+	heartbeatModeCreate = "create"
+	// heartbeatModeUpdate writes the heartbeat, but never creates the table
+	heartbeatModeUpdate = "update"
+	// heartbeatModeCheck only checks the heartbeat, which is written by something else
+	heartbeatModeCheck = "check"
+)
+
+// RouteHeartbeatStorage defines where the heartbeat is stored, and whether pgroute66 writes it
+type RouteHeartbeatStorage struct {
+	// Backend is table (default), or wal to write heartbeats as messages into WAL
+	Backend string `yaml:"backend"`
+	// Database defaults to the database of the dsn of the node
+	Database string `yaml:"database"`
+	Schema   string `yaml:"schema"`
+	Table    string `yaml:"table"`
+	Mode     string `yaml:"mode"`
+}
+
+// RouteHeartbeatConfig defines how often and where the heartbeat is written on the primary of every group
+type RouteHeartbeatConfig struct {
+	Interval              time.Duration `yaml:"interval"`
+	RouteHeartbeatStorage `yaml:",inline"`
+	// Groups overrides the storage for specific groups
+	Groups map[string]RouteHeartbeatStorage `yaml:"groups"`
+}
+
+// HeartbeatInterval returns the interval at which the heartbeat is written
+func (rhc RouteHeartbeatConfig) HeartbeatInterval() time.Duration {
+	if rhc.Interval <= 0 {
+		return defaultHeartbeatInterval
+	}
+
+	return rhc.Interval
+}
+
+// withDefaults returns the storage, with every field that is not set taken from defaults
+func (rhs RouteHeartbeatStorage) withDefaults(defaults RouteHeartbeatStorage) RouteHeartbeatStorage {
+	if rhs.Backend == "" {
+		rhs.Backend = defaults.Backend
+	}
+
+	if rhs.Database == "" {
+		rhs.Database = defaults.Database
+	}
+
+	if rhs.Schema == "" {
+		rhs.Schema = defaults.Schema
+	}
+
+	if rhs.Table == "" {
+		rhs.Table = defaults.Table
+	}
+
+	if rhs.Mode == "" {
+		rhs.Mode = defaults.Mode
+	}
+
+	return rhs
+}
+
+// Avc returns the location of the heartbeat table, and the row of an instance of pgroute66
+func (rhs RouteHeartbeatStorage) Avc(instanceID string) pg.Avc {
+	return pg.Avc{Backend: pg.AvcBackend(rhs.Backend), Schema: rhs.Schema, Table: rhs.Table, InstanceID: instanceID}
+}
+
+// Writes returns whether pgroute66 writes the heartbeat
+func (rhs RouteHeartbeatStorage) Writes() bool {
+	return rhs.Mode != heartbeatModeCheck
+}
+
+// Creates returns whether pgroute66 creates the heartbeat table when it does not exist
+func (rhs RouteHeartbeatStorage) Creates() bool {
+	return rhs.Mode == heartbeatModeCreate
+}
+
+// Storage returns the storage of the heartbeat for a group
+func (rhc RouteHeartbeatConfig) Storage(group string) RouteHeartbeatStorage {
+	defaults := rhc.RouteHeartbeatStorage.withDefaults(RouteHeartbeatStorage{
+		Backend: string(pg.AvcBackendTable),
+		Schema:  pg.AvcSchema,
+		Table:   pg.AvcTable,
+		Mode:    heartbeatModeCreate,
+	})
+
+	return rhc.Groups[group].withDefaults(defaults)
+}
+
+// NodeStorage returns the storage of the heartbeat for a node,
+// which is the storage of the first group (by name) with a storage override that holds the node
+func (rc RouteConfig) NodeStorage(name string) RouteHeartbeatStorage {
+	groups := make([]string, 0, len(rc.Heartbeat.Groups))
+	for group := range rc.Heartbeat.Groups {
+		groups = append(groups, group)
+	}
+
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		if group == "all" || slices.Contains(rc.Groups[group], name) {
+			return rc.Heartbeat.Storage(group)
+		}
+	}
+
+	return rc.Heartbeat.Storage("")
+}
+
+// Validate returns an error when the heartbeat config cannot be used
+func (rhc RouteHeartbeatConfig) Validate() error {
+	storages := map[string]RouteHeartbeatStorage{"": rhc.RouteHeartbeatStorage}
+	for group, storage := range rhc.Groups {
+		storages[group] = storage
+	}
+
+	for group, storage := range storages {
+		switch pg.AvcBackend(storage.Backend) {
+		case "", pg.AvcBackendTable, pg.AvcBackendWAL:
+		default:
+			return fmt.Errorf("invalid heartbeat backend %s for group %s (should be %s or %s)", storage.Backend,
+				group, pg.AvcBackendTable, pg.AvcBackendWAL)
+		}
+
+		switch storage.Mode {
+		case "", heartbeatModeCreate, heartbeatModeUpdate, heartbeatModeCheck:
+		default:
+			return fmt.Errorf("invalid heartbeat mode %s for group %s (should be %s, %s or %s)", storage.Mode,
+				group, heartbeatModeCreate, heartbeatModeUpdate, heartbeatModeCheck)
+		}
+	}
+
+	return nil
+}
+
+// Databases returns all databases the heartbeat is stored in, besides the databases of the dsn's
+func (rhc RouteHeartbeatConfig) Databases() (databases []string) {
+	if rhc.Database != "" {
+		databases = append(databases, rhc.Database)
+	}
+
+	for _, storage := range rhc.Groups {
+		if storage.Database != "" {
+			databases = append(databases, storage.Database)
+		}
+	}
+
+	return databases
+}
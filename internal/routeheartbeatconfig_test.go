@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routeheartbeatconfig", func() {
+	config := RouteConfig{
+		Groups: RouteHostGroups{"cluster": {"host1", "host2"}, "other": {"host3"}},
+		Heartbeat: RouteHeartbeatConfig{
+			RouteHeartbeatStorage: RouteHeartbeatStorage{Database: "pgroute66", Mode: heartbeatModeUpdate},
+			Groups:                map[string]RouteHeartbeatStorage{"cluster": {Schema: "cluster", Mode: heartbeatModeCheck}},
+		},
+	}
+	Context("heartbeat storage", func() {
+		It("should use the defaults for groups without override", func() {
+			storage := config.NodeStorage("host3")
+			Expect(storage.Avc("")).To(Equal(pg.DefaultAvc()))
+			Expect(storage.Database).To(Equal("pgroute66"))
+			Expect(storage.Writes()).To(BeTrue())
+			Expect(storage.Creates()).To(BeFalse())
+		})
+		It("should override the defaults for a group", func() {
+			storage := config.NodeStorage("host2")
+			Expect(storage.Avc("pgr1")).To(Equal(pg.Avc{Backend: pg.AvcBackendTable, Schema: "cluster", Table: pg.AvcTable,
+				InstanceID: "pgr1"}))
+			Expect(storage.Database).To(Equal("pgroute66"))
+			Expect(storage.Writes()).To(BeFalse())
+		})
+		It("should reject invalid modes", func() {
+			Expect(config.Heartbeat.Validate()).To(Succeed())
+			Expect(RouteHeartbeatConfig{RouteHeartbeatStorage: RouteHeartbeatStorage{Mode: "x"}}.Validate()).
+				NotTo(Succeed())
+			Expect(RouteHeartbeatConfig{RouteHeartbeatStorage: RouteHeartbeatStorage{Backend: "x"}}.Validate()).
+				NotTo(Succeed())
+		})
+	})
+	Context("wal backend", func() {
+		wal := RouteHeartbeatConfig{Groups: map[string]RouteHeartbeatStorage{"cluster": {Backend: "wal"}}}
+		It("should be a valid backend", func() {
+			Expect(wal.Validate()).To(Succeed())
+		})
+		It("should be passed on to the availability checker", func() {
+			Expect(wal.Storage("cluster").Avc("pgr1")).To(Equal(pg.Avc{Backend: pg.AvcBackendWAL, Schema: pg.AvcSchema,
+				Table: pg.AvcTable, InstanceID: "pgr1"}))
+			Expect(wal.Storage("other").Avc("pgr1").Backend).To(Equal(pg.AvcBackendTable))
+		})
+		It("should report standbys without replayed heartbeat as heartbeat_missing", func() {
+			Expect(availabilityOutcome(pg.ErrAvcNoHeartbeat)).To(Equal(outcomeHeartbeatMissing))
+			Expect(availabilityOutcome(fmt.Errorf("host2: %w", pg.ErrAvcNoHeartbeat))).To(Equal(outcomeHeartbeatMissing))
+		})
+	})
+	Context("writing without privileges", func() {
+		It("should back off after denials, up to a maximum", func() {
+			Expect(heartbeatBackOff(time.Second, 0)).To(Equal(time.Second))
+			Expect(heartbeatBackOff(time.Second, 1)).To(Equal(2 * time.Second))
+			Expect(heartbeatBackOff(time.Second, 5)).To(Equal(32 * time.Second))
+			Expect(heartbeatBackOff(time.Second, 100)).To(Equal(heartbeatMaxBackOff))
+		})
+		It("should never write less often than the interval", func() {
+			Expect(heartbeatBackOff(2*time.Minute, 3)).To(Equal(2 * time.Minute))
+		})
+	})
+})
@@ -2,5 +2,25 @@ package internal
 
 import "github.com/mannemsolutions/pgroute66/pkg/pg"
 
-// RouteHostsConfig is a map of Postgre DSN's
-type RouteHostsConfig map[string]pg.Dsn
+type (
+	// RouteHostsConfig is a map of RouteHostConfig objects
+	RouteHostsConfig map[string]RouteHostConfig
+	// RouteHostConfig holds the Postgres DSN of a host, together with pgroute66 specific settings for that host.
+	// All keys that are not pgroute66 specific settings are part of the DSN.
+	RouteHostConfig struct {
+		Dsn    pg.Dsn        `yaml:",inline"`
+		Weight int           `yaml:"weight"`
+		Tags   RouteHostTags `yaml:"tags"`
+		// Priority prefers a host for failover over hosts with a lower priority (when they received as much WAL)
+		Priority int `yaml:"priority"`
+	}
+)
+
+// HostWeight returns the weight of this host for weighted load balancing, which defaults to 1
+func (rhc RouteHostConfig) HostWeight() int {
+	if rhc.Weight <= 0 {
+		return 1
+	}
+
+	return rhc.Weight
+}
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"slices"
+	"strings"
+)
+
+const (
+	tagNoFailover    = "nofailover"
+	tagNoLoadBalance = "noloadbalance"
+	tagFalse         = "false"
+)
+
+// RouteHostTags are free-form tags of a host, like zone or datacenter.
+// Tags nofailover and noloadbalance are interpreted by pgroute66.
+type RouteHostTags map[string]string
+
+// Has returns true when a tag is set, and not set to false (like nofailover: true)
+func (rht RouteHostTags) Has(key string) bool {
+	value, exists := rht[key]
+
+	return exists && strings.ToLower(value) != tagFalse
+}
+
+// RouteTagSelector selects hosts by tag. It is parsed from key:value, or from key only (matching any value but false).
+type RouteTagSelector struct {
+	Key      string
+	Value    string
+	AnyValue bool
+}
+
+// NewRouteTagSelector parses a selector from a key:value string
+func NewRouteTagSelector(selector string) RouteTagSelector {
+	key, value, hasValue := strings.Cut(selector, ":")
+
+	return RouteTagSelector{Key: key, Value: value, AnyValue: !hasValue}
+}
+
+// Matches returns true when the tags match this selector
+func (rts RouteTagSelector) Matches(tags RouteHostTags) bool {
+	if rts.AnyValue {
+		return tags.Has(rts.Key)
+	}
+
+	value, exists := tags[rts.Key]
+
+	return exists && value == rts.Value
+}
+
+// RouteNodeFilter filters nodes by tags.
+// All Tags selectors should match, and when Prefer is set, only the nodes matching the first preference that matches
+// any node are kept (falling back to all nodes when no preference matches).
+type RouteNodeFilter struct {
+	Tags   []RouteTagSelector
+	Prefer []RouteTagSelector
+}
+
+// NewRouteNodeFilter returns a RouteNodeFilter for a list of tag and prefer selectors (as key:value strings)
+func NewRouteNodeFilter(tags []string, prefer []string) (rnf RouteNodeFilter) {
+	for _, tag := range tags {
+		rnf.Tags = append(rnf.Tags, NewRouteTagSelector(tag))
+	}
+
+	for _, tag := range prefer {
+		rnf.Prefer = append(rnf.Prefer, NewRouteTagSelector(tag))
+	}
+
+	return rnf
+}
+
+// Apply returns the names that pass this filter, keeping their order
+func (rnf RouteNodeFilter) Apply(hosts RouteHostsConfig, names []string) []string {
+	filtered := slices.DeleteFunc(slices.Clone(names), func(name string) bool {
+		for _, selector := range rnf.Tags {
+			if !selector.Matches(hosts[name].Tags) {
+				return true
+			}
+		}
+
+		return false
+	})
+
+	for _, selector := range rnf.Prefer {
+		preferred := slices.DeleteFunc(slices.Clone(filtered), func(name string) bool {
+			return !selector.Matches(hosts[name].Tags)
+		})
+		if len(preferred) > 0 {
+			return preferred
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,39 @@
+package internal
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routehosttags", func() {
+	hosts := RouteHostsConfig{
+		"host1": {Tags: RouteHostTags{"zone": "dc1"}},
+		"host2": {Tags: RouteHostTags{"zone": "dc2", tagNoFailover: "true"}},
+		"host3": {Tags: RouteHostTags{"zone": "dc2", tagNoFailover: "false"}},
+	}
+	names := []string{"host1", "host2", "host3"}
+	Context("filtering on tags", func() {
+		It("should match key:value selectors", func() {
+			filter := NewRouteNodeFilter([]string{"zone:dc2"}, nil)
+			Expect(filter.Apply(hosts, names)).To(Equal([]string{"host2", "host3"}))
+		})
+		It("should match key only selectors, unless set to false", func() {
+			filter := NewRouteNodeFilter([]string{tagNoFailover}, nil)
+			Expect(filter.Apply(hosts, names)).To(Equal([]string{"host2"}))
+		})
+	})
+	Context("preferring tags", func() {
+		It("should only return preferred nodes", func() {
+			filter := NewRouteNodeFilter(nil, []string{"zone:dc1", "zone:dc2"})
+			Expect(filter.Apply(hosts, names)).To(Equal([]string{"host1"}))
+		})
+		It("should fall back to the next preference", func() {
+			filter := NewRouteNodeFilter(nil, []string{"zone:dc1", "zone:dc2"})
+			Expect(filter.Apply(hosts, []string{"host2", "host3"})).To(Equal([]string{"host2", "host3"}))
+		})
+		It("should fall back to all nodes", func() {
+			filter := NewRouteNodeFilter(nil, []string{"zone:dc3"})
+			Expect(filter.Apply(hosts, names)).To(Equal(names))
+		})
+	})
+})
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+)
+
+/*
+ * This module guards against misconfigured groups, by comparing the system identifier and start time of all members.
+ * Members of another cluster than most members are foreign, and members that are the same server as another member
+ * (e.a. two dsn's for one server address) are duplicates. Misconfigured members are left out of routing answers.
+ * Optionally groups are built from matching system identifiers.
+ */
+
+const (
+	identityForeignCluster = "foreign-cluster"
+	identityDuplicateNode  = "duplicate-node"
+	autoGroupPrefix        = "cluster-"
+)
+
+// nodeIdentities returns the identity of all nodes that are available
+func (prh PgRouteHandler) nodeIdentities(names RouteHostGroup) map[string]pg.NodeIdentity {
+	identities := map[string]pg.NodeIdentity{}
+
+	for name, conn := range prh.connections.FilteredConnections(names) {
+		identity, err := conn.Identity(context.Background())
+		if err != nil {
+			prh.log.Debugf("Could not get identity of node %s, %s", name, err.Error())
+
+			continue
+		}
+
+		identities[name] = identity
+	}
+
+	return identities
+}
+
+// clusterIdentifier returns the system identifier shared by most nodes, preferring the node that sorts first on a tie
+func clusterIdentifier(names []string, identities map[string]pg.NodeIdentity) string {
+	counts := map[string]int{}
+	for _, identity := range identities {
+		counts[identity.SystemIdentifier]++
+	}
+
+	var cluster string
+	for _, name := range names {
+		if sysid := identities[name].SystemIdentifier; counts[sysid] > counts[cluster] {
+			cluster = sysid
+		}
+	}
+
+	return cluster
+}
+
+// identityConflicts returns the nodes that are foreign (another cluster than most nodes),
+// or duplicate (the same server as a node that sorts before it)
+func identityConflicts(identities map[string]pg.NodeIdentity) map[string]string {
+	names := make([]string, 0, len(identities))
+	for name := range identities {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	cluster := clusterIdentifier(names, identities)
+	conflicts := map[string]string{}
+	seen := map[string]bool{}
+
+	for _, name := range names {
+		identity := identities[name]
+		// the address is left out, as one server can be reached on multiple addresses
+		server := identity.SystemIdentifier + "@" + strconv.FormatInt(identity.StartTime.UnixMicro(), 10)
+
+		switch {
+		case identity.SystemIdentifier != cluster:
+			conflicts[name] = identityForeignCluster
+		case seen[server]:
+			conflicts[name] = identityDuplicateNode
+		}
+
+		seen[server] = true
+	}
+
+	return conflicts
+}
+
+// GetIdentities returns the system identifier of all available members of a group,
+// and the members that are foreign or duplicate (only duplicate for group all)
+func (prh PgRouteHandler) GetIdentities(group string) (sysids map[string]string, conflicts map[string]string) {
+	identities := prh.nodeIdentities(prh.config.GroupHosts(group))
+
+	sysids = map[string]string{}
+	for name, identity := range identities {
+		sysids[name] = identity.SystemIdentifier
+	}
+
+	conflicts = identityConflicts(identities)
+	for name, conflict := range conflicts {
+		// the special group all holds the nodes of all clusters
+		if group == "all" && conflict == identityForeignCluster {
+			delete(conflicts, name)
+
+			continue
+		}
+
+		prh.log.Warnf("node %s of group %s is a %s (system identifier %s, server %s:%d)", name, group, conflict,
+			identities[name].SystemIdentifier, identities[name].ServerAddr, identities[name].ServerPort)
+	}
+
+	return sysids, conflicts
+}
+
+// misconfigured returns the foreign and duplicate members of a group, cached for cache_interval
+func (prh PgRouteHandler) misconfigured(group string) map[string]string {
+	if conflicts, cached := prh.conflicts.get(group, prh.config.StateCacheInterval()); cached {
+		return conflicts
+	}
+
+	_, conflicts := prh.GetIdentities(group)
+	prh.conflicts.set(group, conflicts)
+
+	return conflicts
+}
+
+// withoutConflicts returns nodes, leaving out the nodes that are foreign or duplicate members of a group
+func (prh PgRouteHandler) withoutConflicts(group string, nodes []string) []string {
+	if len(nodes) == 0 {
+		return nodes
+	}
+
+	conflicts := prh.misconfigured(group)
+
+	return slices.DeleteFunc(nodes, func(name string) bool {
+		if conflict, exists := conflicts[name]; exists {
+			prh.log.Debugf("Leaving out node %s, which is a %s", name, conflict)
+
+			return true
+		}
+
+		return false
+	})
+}
+
+// addAutoGroups adds a group for every system identifier of the nodes that are available,
+// named cluster-<system identifier>, unless a group with that name is defined in config
+func (prh *PgRouteHandler) addAutoGroups() {
+	if prh.config.Groups == nil {
+		prh.config.Groups = RouteHostGroups{}
+	}
+
+	autoGroups := RouteHostGroups{}
+
+	for name, identity := range prh.nodeIdentities(prh.config.GroupHosts("all")) {
+		group := autoGroupPrefix + identity.SystemIdentifier
+		autoGroups[group] = append(autoGroups[group], name)
+	}
+
+	for group, members := range autoGroups {
+		if _, exists := prh.config.Groups[group]; exists {
+			continue
+		}
+
+		sort.Strings(members)
+		prh.log.Infof("Adding group %s with nodes %v", group, members)
+		prh.config.Groups[group] = members
+	}
+}
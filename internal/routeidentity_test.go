@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routeidentity", func() {
+	started := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	Context("detecting misconfigured groups", func() {
+		It("should not report nodes of one cluster", func() {
+			Expect(identityConflicts(map[string]pg.NodeIdentity{
+				"host1": {SystemIdentifier: "1", StartTime: started},
+				"host2": {SystemIdentifier: "1", StartTime: started.Add(time.Second)},
+			})).To(BeEmpty())
+		})
+		It("should report nodes of another cluster than most nodes", func() {
+			Expect(identityConflicts(map[string]pg.NodeIdentity{
+				"host1": {SystemIdentifier: "2", StartTime: started},
+				"host2": {SystemIdentifier: "1", StartTime: started.Add(time.Second)},
+				"host3": {SystemIdentifier: "1", StartTime: started.Add(time.Minute)},
+			})).To(Equal(map[string]string{"host1": identityForeignCluster}))
+		})
+		It("should report the same server as duplicate", func() {
+			Expect(identityConflicts(map[string]pg.NodeIdentity{
+				"host1": {SystemIdentifier: "1", StartTime: started, ServerAddr: "10.0.0.1", ServerPort: 5432},
+				"host2": {SystemIdentifier: "1", StartTime: started, ServerAddr: "10.0.0.1", ServerPort: 5432},
+			})).To(Equal(map[string]string{"host2": identityDuplicateNode}))
+		})
+		It("should report the same server on another address as duplicate", func() {
+			Expect(identityConflicts(map[string]pg.NodeIdentity{
+				"host1": {SystemIdentifier: "1", StartTime: started, ServerAddr: "10.0.0.1", ServerPort: 5432},
+				"host2": {SystemIdentifier: "1", StartTime: started, ServerAddr: "10.0.0.2", ServerPort: 5432},
+			})).To(Equal(map[string]string{"host2": identityDuplicateNode}))
+		})
+	})
+	Context("routing around misconfigured nodes", func() {
+		It("should leave out foreign and duplicate nodes", func() {
+			handler := newTestHandler(RouteConfig{})
+			handler.conflicts.set("cluster", map[string]string{
+				"host2": identityForeignCluster,
+				"host3": identityDuplicateNode,
+			})
+			Expect(handler.withoutConflicts("cluster", []string{"host1", "host2", "host3"})).To(Equal([]string{"host1"}))
+		})
+	})
+})
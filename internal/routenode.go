@@ -0,0 +1,38 @@
+package internal
+
+import "sort"
+
+// RouteNode describes a node in the inventory
+type RouteNode struct {
+	Name   string        `json:"name" yaml:"name"`
+	Host   string        `json:"host" yaml:"host"`
+	Port   string        `json:"port" yaml:"port"`
+	Weight int           `json:"weight" yaml:"weight"`
+	Tags   RouteHostTags `json:"tags" yaml:"tags"`
+	Groups []string      `json:"groups" yaml:"groups"`
+}
+
+// GetNodes returns the inventory of all nodes in a group that pass a filter, sorted by name
+func (prh PgRouteHandler) GetNodes(group string, filter RouteNodeFilter) (nodes []RouteNode) {
+	var names []string
+	for name := range prh.connections.FilteredConnections(prh.config.GroupHosts(group)) {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range filter.Apply(prh.config.Hosts, names) {
+		node := RouteNode{
+			Name:   name,
+			Host:   prh.connections[name].Host(),
+			Port:   prh.connections[name].Port(),
+			Weight: prh.config.Hosts[name].HostWeight(),
+			Tags:   prh.config.Hosts[name].Tags,
+			Groups: append([]string{}, prh.config.NodeGroups(name)...),
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// RoutePgRouterRule maps connections to a group by the requested database and / or user.
+// Database and User are regular expressions which should match the full value. An empty expression matches all.
+type RoutePgRouterRule struct {
+	Database string `yaml:"database"`
+	User     string `yaml:"user"`
+	Group    string `yaml:"group"`
+	// database and user are the compiled expressions (nil for an empty expression), set by Validate
+	database *regexp.Regexp
+	user     *regexp.Regexp
+	compiled bool
+}
+
+// compileFull compiles an expression which should match the full value, or returns nil for an empty expression
+func compileFull(expression string) (*regexp.Regexp, error) {
+	if expression == "" {
+		return nil, nil
+	}
+
+	return regexp.Compile("^(?:" + expression + ")$")
+}
+
+// compile compiles both expressions of the rule, so that connections are matched without compiling them again
+func (rprr *RoutePgRouterRule) compile() (err error) {
+	if rprr.database, err = compileFull(rprr.Database); err != nil {
+		return err
+	}
+
+	if rprr.user, err = compileFull(rprr.User); err != nil {
+		return err
+	}
+
+	rprr.compiled = true
+
+	return nil
+}
+
+func fullMatch(expression *regexp.Regexp, value string) bool {
+	return expression == nil || expression.MatchString(value)
+}
+
+// Matches returns true when the rule applies to a connection request for this database and user.
+// The rule should be compiled by Validate of its router config.
+func (rprr RoutePgRouterRule) Matches(database string, user string) (bool, error) {
+	if !rprr.compiled {
+		return false, errors.New("router rule is not validated")
+	}
+
+	return fullMatch(rprr.database, database) && fullMatch(rprr.user, user), nil
+}
+
+// RoutePgRouterConfig defines a listener which reads the PostgreSQL startup packet and routes the connection to the
+// primary (or for read only users a standby) of the group selected by the rules.
+type RoutePgRouterConfig struct {
+	Listen        string              `yaml:"listen"`
+	Rules         []RoutePgRouterRule `yaml:"rules"`
+	ReadOnlyUsers []string            `yaml:"readonly_users"`
+	Interval      time.Duration       `yaml:"check_interval"`
+}
+
+// CheckInterval returns the interval at which existing sessions are verified against the current topology
+func (rprc RoutePgRouterConfig) CheckInterval() time.Duration {
+	if rprc.Interval <= 0 {
+		return defaultProxyInterval
+	}
+
+	return rprc.Interval
+}
+
+// Validate returns an error when this router config cannot be used, e.a. when a rule routes to a group that is not
+// defined in config, and compiles the expressions of all rules
+func (rprc *RoutePgRouterConfig) Validate(config RouteConfig) error {
+	if rprc.Listen == "" {
+		return errors.New("router has no listen address")
+	}
+
+	for i := range rprc.Rules {
+		rule := &rprc.Rules[i]
+		if rule.Group == "" {
+			return fmt.Errorf("router on %s has a rule without a group", rprc.Listen)
+		} else if !config.HasGroup(rule.Group) {
+			return fmt.Errorf("router on %s has a rule for group %s, which is not defined", rprc.Listen, rule.Group)
+		}
+
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("router on %s has a rule with an invalid expression: %w", rprc.Listen, err)
+		}
+	}
+
+	return nil
+}
+
+// Route returns the group and role a connection for this database and user should be routed to
+func (rprc RoutePgRouterConfig) Route(database string, user string) (group string, role string, err error) {
+	role = proxyRolePrimary
+	if slices.Contains(rprc.ReadOnlyUsers, user) {
+		role = proxyRoleStandby
+	}
+
+	for _, rule := range rprc.Rules {
+		if matches, matchErr := rule.Matches(database, user); matchErr != nil {
+			return "", "", matchErr
+		} else if matches {
+			return rule.Group, role, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("no route for database %s and user %s", database, user)
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routepgrouterconfig", func() {
+	config := RouteConfig{Groups: RouteHostGroups{"cluster": {"host1"}}}
+	router := func(rules ...RoutePgRouterRule) *RoutePgRouterConfig {
+		return &RoutePgRouterConfig{Listen: ":5432", Rules: rules}
+	}
+	Context("validate", func() {
+		It("should accept valid rules", func() {
+			Expect(router(RoutePgRouterRule{Database: "app.*", User: "app", Group: "cluster"},
+				RoutePgRouterRule{Group: "all"}).Validate(config)).To(Succeed())
+		})
+		It("should reject an invalid user expression, also when the database expression does not match", func() {
+			Expect(router(RoutePgRouterRule{Database: "app", User: "(", Group: "cluster"}).Validate(config)).
+				NotTo(Succeed())
+		})
+		It("should reject an invalid database expression", func() {
+			Expect(router(RoutePgRouterRule{Database: "[", Group: "cluster"}).Validate(config)).NotTo(Succeed())
+		})
+		It("should reject rules for groups that are not defined", func() {
+			Expect(router(RoutePgRouterRule{Group: "other"}).Validate(config)).NotTo(Succeed())
+			Expect(router(RoutePgRouterRule{}).Validate(config)).NotTo(Succeed())
+		})
+	})
+	Context("route", func() {
+		It("should route to the first matching rule", func() {
+			rprc := router(RoutePgRouterRule{Database: "app", Group: "cluster"}, RoutePgRouterRule{Group: "all"})
+			rprc.ReadOnlyUsers = []string{"reporting"}
+			Expect(rprc.Validate(config)).To(Succeed())
+
+			group, role, err := rprc.Route("app", "reporting")
+			Expect(err).NotTo(HaveOccurred())
+			Expect([]string{group, role}).To(Equal([]string{"cluster", proxyRoleStandby}))
+
+			group, role, err = rprc.Route("other", "app")
+			Expect(err).NotTo(HaveOccurred())
+			Expect([]string{group, role}).To(Equal([]string{"all", proxyRolePrimary}))
+		})
+		It("should match the full value with the compiled expressions", func() {
+			rprc := router(RoutePgRouterRule{Database: "app", User: "batch_.*", Group: "cluster"})
+			Expect(rprc.Validate(config)).To(Succeed())
+			Expect(rprc.Rules[0].Matches("app", "batch_1")).To(BeTrue())
+			Expect(rprc.Rules[0].Matches("app2", "batch_1")).To(BeFalse())
+			Expect(rprc.Rules[0].Matches("app", "x_batch_1")).To(BeFalse())
+		})
+		It("should not route with rules that are not validated", func() {
+			_, _, err := router(RoutePgRouterRule{Group: "all"}).Route("app", "app")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	proxyRolePrimary     = "primary"
+	proxyRoleStandby     = "standby"
+	defaultProxyInterval = time.Second
+	// proxyDialTimeout limits connecting to a node, so that an unreachable node does not hang client connections
+	proxyDialTimeout = 5 * time.Second
+)
+
+// RouteProxyConfig defines a listener that forwards client connections to a node of a group
+type RouteProxyConfig struct {
+	Group    string        `yaml:"group"`
+	Listen   string        `yaml:"listen"`
+	Role     string        `yaml:"role"`
+	Interval time.Duration `yaml:"check_interval"`
+}
+
+// GroupName returns the group this proxy forwards to, defaulting to "all"
+func (rpc RouteProxyConfig) GroupName() string {
+	if rpc.Group == "" {
+		return "all"
+	}
+
+	return rpc.Group
+}
+
+// RoleName returns the role of the nodes this proxy forwards to, defaulting to primary
+func (rpc RouteProxyConfig) RoleName() string {
+	if rpc.Role == "" {
+		return proxyRolePrimary
+	}
+
+	return rpc.Role
+}
+
+// CheckInterval returns the interval at which existing sessions are verified against the current topology
+func (rpc RouteProxyConfig) CheckInterval() time.Duration {
+	if rpc.Interval <= 0 {
+		return defaultProxyInterval
+	}
+
+	return rpc.Interval
+}
+
+// Validate returns an error when this proxy config cannot be used
+func (rpc RouteProxyConfig) Validate() error {
+	if rpc.Listen == "" {
+		return fmt.Errorf("proxy for group %s has no listen address", rpc.GroupName())
+	}
+
+	switch rpc.RoleName() {
+	case proxyRolePrimary, proxyRoleStandby:
+		return nil
+	default:
+		return fmt.Errorf("proxy on %s has invalid role %s (should be %s or %s)",
+			rpc.Listen, rpc.Role, proxyRolePrimary, proxyRoleStandby)
+	}
+}
@@ -0,0 +1,277 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+ * This module keeps rolling availability statistics of every group, for reporting SLOs.
+ * At every interval the primaries and the status of all members are sampled, and the sampled time is added
+ * to a bucket per minute. Buckets older than the longest window (7 days) are dropped,
+ * and all buckets can be persisted to a JSON file, so that statistics survive a restart.
+ */
+
+const (
+	statsPersistInterval = time.Minute
+	statsFileMode        = 0o600
+)
+
+// statsWindows are the windows statistics are reported for, the last one being the longest
+var statsWindows = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+}
+
+// routeStatsBucket holds the number of seconds sampled in one minute, and how many of those a state applied
+type routeStatsBucket struct {
+	// Minute is the start of the minute, in unix seconds
+	Minute     int64              `json:"minute"`
+	Observed   float64            `json:"observed"`
+	Primary    float64            `json:"primary"`
+	SplitBrain float64            `json:"split_brain"`
+	Up         map[string]float64 `json:"up"`
+}
+
+// routeStats holds the buckets of all groups
+type routeStats struct {
+	mutex   sync.Mutex
+	buckets map[string][]routeStatsBucket
+}
+
+// overlap returns the part (0 to 1) of the minute of a bucket that is after since
+func (rsb routeStatsBucket) overlap(since time.Time) float64 {
+	end := time.Unix(rsb.Minute, 0).Add(time.Minute)
+
+	return min(max(end.Sub(since).Seconds()/time.Minute.Seconds(), 0), 1)
+}
+
+func newRouteStats() *routeStats {
+	return &routeStats{buckets: map[string][]routeStatsBucket{}}
+}
+
+// RouteStatsWindow holds the statistics of a group over a window
+type RouteStatsWindow struct {
+	// Observed is the number of seconds of the window that was sampled
+	Observed float64 `json:"observed" yaml:"observed"`
+	// PrimaryPresent is the percentage of the observed time that the group had exactly one primary
+	PrimaryPresent float64 `json:"primary_present" yaml:"primary_present"`
+	// SplitBrain is the number of seconds that the group had multiple primaries
+	SplitBrain float64 `json:"split_brain" yaml:"split_brain"`
+	// Uptime is the percentage of the observed time that every member was available
+	Uptime map[string]float64 `json:"uptime" yaml:"uptime"`
+}
+
+// RouteGroupStats holds the statistics of a group over all windows (1h, 24h and 7d)
+type RouteGroupStats struct {
+	Name    string                      `json:"name" yaml:"name"`
+	Windows map[string]RouteStatsWindow `json:"windows" yaml:"windows"`
+}
+
+// record adds a sample of a group, which covers weight, to the bucket of the minute at
+func (rs *routeStats) record(group string, at time.Time, weight time.Duration, primaries int, up map[string]bool) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	minute := at.Truncate(time.Minute).Unix()
+	buckets := rs.buckets[group]
+
+	if len(buckets) == 0 || buckets[len(buckets)-1].Minute != minute {
+		buckets = append(buckets, routeStatsBucket{Minute: minute, Up: map[string]float64{}})
+	}
+
+	bucket := &buckets[len(buckets)-1]
+	seconds := weight.Seconds()
+	bucket.Observed += seconds
+
+	switch {
+	case primaries == 1:
+		bucket.Primary += seconds
+	case primaries > 1:
+		bucket.SplitBrain += seconds
+	}
+
+	for name, isUp := range up {
+		if isUp {
+			bucket.Up[name] += seconds
+		}
+	}
+
+	// buckets that are partially within the longest window are kept
+	oldest := at.Add(-statsWindows[len(statsWindows)-1].duration)
+	for len(buckets) > 0 && buckets[0].overlap(oldest) == 0 {
+		buckets = buckets[1:]
+	}
+
+	rs.buckets[group] = buckets
+}
+
+// window returns the statistics of a group over the window before now.
+// The bucket of the minute in which the window starts only counts for the part that is within the window.
+func (rs *routeStats) window(group string, now time.Time, duration time.Duration, members []string) RouteStatsWindow {
+	var primary float64
+
+	window := RouteStatsWindow{Uptime: map[string]float64{}}
+	up := map[string]float64{}
+	since := now.Add(-duration)
+
+	for _, bucket := range rs.buckets[group] {
+		part := bucket.overlap(since)
+		if part == 0 {
+			continue
+		}
+
+		window.Observed += part * bucket.Observed
+		primary += part * bucket.Primary
+		window.SplitBrain += part * bucket.SplitBrain
+
+		for name, seconds := range bucket.Up {
+			up[name] += part * seconds
+		}
+	}
+
+	if window.Observed == 0 {
+		return window
+	}
+
+	window.PrimaryPresent = 100 * primary / window.Observed
+	for _, name := range members {
+		window.Uptime[name] = 100 * up[name] / window.Observed
+	}
+
+	return window
+}
+
+// summary returns the statistics of a group over all windows before now
+func (rs *routeStats) summary(group string, now time.Time, members []string) RouteGroupStats {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	stats := RouteGroupStats{Name: group, Windows: map[string]RouteStatsWindow{}}
+	for _, window := range statsWindows {
+		stats.Windows[window.name] = rs.window(group, now, window.duration, members)
+	}
+
+	return stats
+}
+
+// save writes all buckets to a JSON file
+func (rs *routeStats) save(path string) error {
+	rs.mutex.Lock()
+	data, err := json.Marshal(rs.buckets)
+	rs.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// write and rename, so that a crash never leaves a partially written file
+	if err = os.WriteFile(path+".tmp", data, statsFileMode); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// load reads all buckets from a JSON file, which is fine to not exist yet
+func (rs *routeStats) load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	buckets := map[string][]routeStatsBucket{}
+	if err = json.Unmarshal(data, &buckets); err != nil {
+		return fmt.Errorf("invalid statistics file %s: %w", path, err)
+	}
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	rs.buckets = buckets
+
+	return nil
+}
+
+// statsGroups returns the groups to keep statistics for: all groups, and the special group all
+func (prh PgRouteHandler) statsGroups() []string {
+	return append(prh.GetGroups(), "all")
+}
+
+// sampleStats samples the primaries and the status of all members of a group
+func (prh PgRouteHandler) sampleStats(group string, at time.Time, weight time.Duration) {
+	primaries := 0
+	up := map[string]bool{}
+
+	for name := range prh.connections.FilteredConnections(prh.config.GroupHosts(group)) {
+		switch status := prh.GetNodeStatus(name); {
+		case status == ghStatusPrimary && !prh.isFencedPrimary(name):
+			primaries++
+			up[name] = true
+		case status == ghStatusPrimary, status == ghStatusStandby:
+			up[name] = true
+		default:
+			up[name] = false
+		}
+	}
+
+	prh.stats.record(group, at, weight, primaries, up)
+}
+
+// collectStats samples all groups at every interval, and persists the statistics every minute (when configured)
+func (prh PgRouteHandler) collectStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	persisted := time.Now()
+
+	for at := range ticker.C {
+		for _, group := range prh.statsGroups() {
+			prh.sampleStats(group, at, interval)
+		}
+
+		if prh.config.Stats.File == "" || at.Sub(persisted) < statsPersistInterval {
+			continue
+		}
+
+		if err := prh.stats.save(prh.config.Stats.File); err != nil {
+			prh.log.Errorf("failed to persist statistics to %s: %s", prh.config.Stats.File, err.Error())
+		}
+
+		persisted = at
+	}
+}
+
+// RunStats loads persisted statistics, and starts sampling them in the background
+func (prh *PgRouteHandler) RunStats() {
+	if prh.config.Stats.File != "" {
+		if err := prh.stats.load(prh.config.Stats.File); err != nil {
+			prh.log.Errorf("failed to load statistics: %s", err.Error())
+		}
+	}
+
+	interval := prh.config.Stats.StatsInterval()
+	prh.log.Infof("Sampling availability statistics every %s", interval)
+
+	go prh.collectStats(interval)
+}
+
+// GetGroupStats returns the availability statistics of a group over the last 1h, 24h and 7d
+func (prh PgRouteHandler) GetGroupStats(group string) (RouteGroupStats, error) {
+	if !prh.config.HasGroup(group) {
+		return RouteGroupStats{}, fmt.Errorf("group %s is not defined", group)
+	}
+
+	return prh.stats.summary(group, time.Now(), prh.config.GroupHosts(group)), nil
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routestats", func() {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	members := []string{"host1", "host2"}
+	newStats := func() *routeStats {
+		stats := newRouteStats()
+		for i := range 60 {
+			at := start.Add(time.Duration(i) * time.Minute)
+			switch {
+			case i < 45:
+				stats.record("cluster", at, time.Minute, 1, map[string]bool{"host1": true, "host2": true})
+			case i < 50:
+				stats.record("cluster", at, time.Minute, 2, map[string]bool{"host1": true, "host2": true})
+			default:
+				stats.record("cluster", at, time.Minute, 0, map[string]bool{"host1": false, "host2": true})
+			}
+		}
+
+		return stats
+	}
+	Context("summary", func() {
+		It("should report primary presence, split brain and uptime", func() {
+			window := newStats().summary("cluster", start.Add(time.Hour), members).Windows["1h"]
+			Expect(window.Observed).To(Equal(3600.0))
+			Expect(window.PrimaryPresent).To(Equal(75.0))
+			Expect(window.SplitBrain).To(Equal(300.0))
+			Expect(window.Uptime).To(Equal(map[string]float64{"host1": 100 * 50 / 60.0, "host2": 100.0}))
+		})
+		It("should only count buckets within a window", func() {
+			stats := newStats().summary("cluster", start.Add(25*time.Hour), members)
+			Expect(stats.Windows["1h"].Observed).To(BeZero())
+			Expect(stats.Windows["1h"].Uptime).To(BeEmpty())
+			Expect(stats.Windows["7d"].Observed).To(Equal(3600.0))
+		})
+		It("should drop buckets older than the longest window", func() {
+			stats := newStats()
+			stats.record("cluster", start.Add(8*24*time.Hour), time.Minute, 1, map[string]bool{})
+			Expect(stats.buckets["cluster"]).To(HaveLen(1))
+		})
+	})
+	Context("window boundaries", func() {
+		It("should only count the part of the first minute that is within the window", func() {
+			window := newStats().summary("cluster", start.Add(time.Hour+30*time.Second), members).Windows["1h"]
+			Expect(window.Observed).To(Equal(3570.0))
+			Expect(window.SplitBrain).To(Equal(300.0))
+		})
+		It("should not count the minute before the window", func() {
+			window := newStats().summary("cluster", start.Add(time.Hour+time.Minute), members).Windows["1h"]
+			Expect(window.Observed).To(Equal(3540.0))
+			Expect(window.PrimaryPresent).To(Equal(100 * 44 / 59.0))
+		})
+		It("should keep buckets that are partially within the longest window", func() {
+			stats := newStats()
+			stats.record("cluster", start.Add(7*24*time.Hour+30*time.Second), time.Minute, 1, map[string]bool{})
+			Expect(stats.buckets["cluster"]).To(HaveLen(61))
+			Expect(stats.buckets["cluster"][0].Minute).To(Equal(start.Unix()))
+		})
+		It("should drop buckets that ended before the longest window", func() {
+			stats := newStats()
+			stats.record("cluster", start.Add(7*24*time.Hour+time.Minute), time.Minute, 1, map[string]bool{})
+			Expect(stats.buckets["cluster"]).To(HaveLen(60))
+			Expect(stats.buckets["cluster"][0].Minute).To(Equal(start.Add(time.Minute).Unix()))
+		})
+	})
+	Context("persistence", func() {
+		It("should load what was saved", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "stats.json")
+			stats := newStats()
+			Expect(stats.save(path)).To(Succeed())
+
+			loaded := newRouteStats()
+			Expect(loaded.load(path)).To(Succeed())
+			Expect(loaded.buckets).To(Equal(stats.buckets))
+		})
+		It("should start empty without file", func() {
+			loaded := newRouteStats()
+			Expect(loaded.load(filepath.Join(GinkgoT().TempDir(), "missing.json"))).To(Succeed())
+			Expect(loaded.buckets).To(BeEmpty())
+		})
+	})
+})
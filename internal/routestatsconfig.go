@@ -0,0 +1,21 @@
+package internal
+
+import "time"
+
+const defaultStatsInterval = 10 * time.Second
+
+// RouteStatsConfig defines how often availability statistics are sampled, and where they are persisted
+type RouteStatsConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	// File persists the statistics (as JSON) across restarts, when set
+	File string `yaml:"file"`
+}
+
+// StatsInterval returns the interval at which availability statistics are sampled
+func (rsc RouteStatsConfig) StatsInterval() time.Duration {
+	if rsc.Interval <= 0 {
+		return defaultStatsInterval
+	}
+
+	return rsc.Interval
+}
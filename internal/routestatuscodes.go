@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+const (
+	outcomeOk             = "ok"
+	outcomeInvalidRequest = "invalid_request"
+	outcomeInvalidNode    = "invalid_node"
+	outcomeNotFound       = "not_found"
+	outcomeSplitBrain     = "split_brain"
+	outcomeUnavailable    = "unavailable"
+	outcomeLagExceeded    = "lag_exceeded"
+	outcomeCheckFailed    = "check_failed"
+	outcomeUnknownGroup   = "unknown_group"
+	// outcomeSyncUnsatisfied means the primary has fewer synchronous standbys than synchronous_standby_names requires
+	outcomeSyncUnsatisfied = "sync_unsatisfied"
+	// outcomePermissionDenied means pgroute66 lacks privileges on the heartbeat table
+	outcomePermissionDenied = "permission_denied"
+	// outcomeHeartbeatMissing means the heartbeat table does not exist
+	outcomeHeartbeatMissing = "heartbeat_missing"
+	// outcomeUnauthorized means the admin token is missing or invalid
+	outcomeUnauthorized = "unauthorized"
+	// outcomeForbidden means the admin api is disabled
+	outcomeForbidden = "forbidden"
+	// outcomeSwitchoverRejected means a switchover did not start, as a precondition was not met
+	outcomeSwitchoverRejected = "switchover_rejected"
+	// outcomeSwitchoverFailed means a step of a switchover failed
+	outcomeSwitchoverFailed = "switchover_failed"
+)
+
+// outcomes lists all outcomes of the v2 api that can be mapped to a status code
+var outcomes = []string{
+	outcomeOk, outcomeInvalidRequest, outcomeInvalidNode, outcomeNotFound, outcomeSplitBrain, outcomeUnavailable,
+	outcomeLagExceeded, outcomeCheckFailed, outcomeUnknownGroup, outcomeSyncUnsatisfied, outcomePermissionDenied,
+	outcomeHeartbeatMissing, outcomeUnauthorized, outcomeForbidden, outcomeSwitchoverRejected,
+	outcomeSwitchoverFailed,
+}
+
+// maxStatusCode is the highest HTTP status code (server errors are 5xx)
+const maxStatusCode = 599
+
+// RouteStatusCodes maps outcomes of the v2 api (like split_brain) to HTTP status codes.
+// Outcomes that are not mapped use a default status code.
+type RouteStatusCodes map[string]int
+
+func defaultStatusCode(outcome string) int {
+	switch outcome {
+	case outcomeOk:
+		return http.StatusOK
+	case outcomeInvalidRequest:
+		return http.StatusBadRequest
+	case outcomeInvalidNode, outcomeNotFound, outcomeUnknownGroup:
+		return http.StatusNotFound
+	case outcomeUnauthorized:
+		return http.StatusUnauthorized
+	case outcomeForbidden:
+		return http.StatusForbidden
+	case outcomeSplitBrain, outcomeSwitchoverRejected:
+		return http.StatusConflict
+	case outcomeUnavailable, outcomeLagExceeded, outcomeSyncUnsatisfied, outcomeHeartbeatMissing,
+		outcomePermissionDenied:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// StatusCode returns the HTTP status code for an outcome
+func (rsc RouteStatusCodes) StatusCode(outcome string) int {
+	if code, exists := rsc[outcome]; exists {
+		return code
+	}
+
+	return defaultStatusCode(outcome)
+}
+
+// Validate returns an error when an outcome is unknown (e.a. a typo),
+// or mapped to a status code that is not a valid HTTP status code
+func (rsc RouteStatusCodes) Validate() error {
+	for outcome, code := range rsc {
+		if !slices.Contains(outcomes, outcome) {
+			return fmt.Errorf("unknown outcome %s in status codes (should be one of %v)", outcome, outcomes)
+		}
+
+		if code < http.StatusContinue || code > maxStatusCode {
+			return fmt.Errorf("status code %d for %s is not a valid HTTP status code (%d-%d)", code, outcome,
+				http.StatusContinue, maxStatusCode)
+		}
+	}
+
+	return nil
+}
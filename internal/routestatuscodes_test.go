@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routestatuscodes", func() {
+	Context("status codes", func() {
+		It("should default by outcome", func() {
+			codes := RouteStatusCodes{}
+			Expect(codes.StatusCode(outcomeOk)).To(Equal(http.StatusOK))
+			Expect(codes.StatusCode(outcomeUnknownGroup)).To(Equal(http.StatusNotFound))
+			Expect(codes.StatusCode(outcomeSplitBrain)).To(Equal(http.StatusConflict))
+			Expect(codes.StatusCode(outcomeLagExceeded)).To(Equal(http.StatusServiceUnavailable))
+			Expect(codes.StatusCode(outcomeCheckFailed)).To(Equal(http.StatusInternalServerError))
+		})
+		It("should report a heartbeat table that cannot be read as unavailable", func() {
+			codes := RouteStatusCodes{}
+			Expect(codes.StatusCode(outcomeHeartbeatMissing)).To(Equal(http.StatusServiceUnavailable))
+			Expect(codes.StatusCode(outcomePermissionDenied)).To(Equal(http.StatusServiceUnavailable))
+		})
+		It("should use configured status codes", func() {
+			codes := RouteStatusCodes{outcomeSplitBrain: http.StatusServiceUnavailable}
+			Expect(codes.StatusCode(outcomeSplitBrain)).To(Equal(http.StatusServiceUnavailable))
+			Expect(codes.StatusCode(outcomeNotFound)).To(Equal(http.StatusNotFound))
+		})
+	})
+	Context("validate", func() {
+		It("should accept valid HTTP status codes", func() {
+			Expect(RouteStatusCodes{outcomeSplitBrain: http.StatusOK, outcomeNotFound: 599}.Validate()).To(Succeed())
+		})
+		It("should reject invalid HTTP status codes", func() {
+			for _, code := range []int{0, 99, 600, 1000} {
+				Expect(RouteStatusCodes{outcomeSplitBrain: code}.Validate()).NotTo(Succeed(), "%d", code)
+			}
+		})
+		It("should reject unknown outcomes", func() {
+			Expect(RouteStatusCodes{"lag_exceded": http.StatusOK}.Validate()).
+				To(MatchError(ContainSubstring("unknown outcome lag_exceded")))
+		})
+		It("should accept all outcomes", func() {
+			codes := RouteStatusCodes{}
+			for _, outcome := range outcomes {
+				codes[outcome] = http.StatusOK
+			}
+			Expect(codes.Validate()).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,35 @@
+package internal
+
+import "time"
+
+const (
+	// defaultSwitchoverMaxLag is 16MB, which is one WAL segment with the default wal_segment_size
+	defaultSwitchoverMaxLag  = 16 * 1024 * 1024
+	defaultSwitchoverTimeout = 30 * time.Second
+)
+
+// RouteSwitchoverConfig defines the limits of a switchover
+type RouteSwitchoverConfig struct {
+	// MaxLag is the maximum number of bytes the target may lag behind before a switchover starts
+	MaxLag int64 `yaml:"max_lag"`
+	// Timeout is the maximum time to wait for the target to replay all WAL, and for the promotion to complete
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// SwitchoverMaxLag returns the maximum number of bytes the target may lag behind
+func (rsc RouteSwitchoverConfig) SwitchoverMaxLag() int64 {
+	if rsc.MaxLag <= 0 {
+		return defaultSwitchoverMaxLag
+	}
+
+	return rsc.MaxLag
+}
+
+// SwitchoverTimeout returns the maximum time to wait for replay, and for promotion
+func (rsc RouteSwitchoverConfig) SwitchoverTimeout() time.Duration {
+	if rsc.Timeout <= 0 {
+		return defaultSwitchoverTimeout
+	}
+
+	return rsc.Timeout
+}
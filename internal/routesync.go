@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+/*
+ * This module checks synchronous replication of the primary of a group: synchronous_standby_names on the primary,
+ * and the sync_state of its standbys in pg_stat_replication.
+ */
+
+var (
+	// errNoPrimary is returned when a group has no primary
+	errNoPrimary = errors.New("no primary available")
+	// errMultiplePrimaries is returned when a group has multiple primaries (split brain)
+	errMultiplePrimaries = errors.New("multiple primaries available")
+)
+
+// RouteSyncState describes synchronous replication of the primary of a group
+type RouteSyncState struct {
+	Primary string `json:"primary" yaml:"primary"`
+	// Required is the number of synchronous standbys commits wait for
+	Required int `json:"required" yaml:"required"`
+	// Connected is the number of connected synchronous standbys (also those that are not a member of the group)
+	Connected int `json:"connected" yaml:"connected"`
+	// Standbys are the members of the group that are a connected synchronous standby
+	Standbys []string `json:"standbys" yaml:"standbys"`
+}
+
+// Satisfied returns whether enough synchronous standbys are connected for commits to complete
+func (rss RouteSyncState) Satisfied() bool {
+	return rss.Connected >= rss.Required
+}
+
+// Error returns an error describing why synchronous replication is not satisfied, or nil when it is
+func (rss RouteSyncState) Error() error {
+	if rss.Satisfied() {
+		return nil
+	}
+
+	return fmt.Errorf("primary %s has %d of %d required synchronous standbys", rss.Primary, rss.Connected,
+		rss.Required)
+}
+
+// singlePrimary returns the primary of a group, or an error when there is no primary, or more than one
+func (prh PgRouteHandler) singlePrimary(group string) (string, error) {
+	primaries := prh.GetPrimaries(group)
+	switch len(primaries) {
+	case 0:
+		return "", errNoPrimary
+	case 1:
+		return primaries[0], nil
+	default:
+		return "", errMultiplePrimaries
+	}
+}
+
+// nodePrimary returns the primary of the (first) group of a node, or of group all for nodes without group
+func (prh PgRouteHandler) nodePrimary(name string) (string, error) {
+	group := "all"
+	if groups := prh.config.NodeGroups(name); len(groups) > 0 {
+		group = groups[0]
+	}
+
+	return prh.singlePrimary(group)
+}
+
+// GetSyncState returns the synchronous replication state of the primary of a group
+func (prh PgRouteHandler) GetSyncState(group string) (state RouteSyncState, err error) {
+	if state.Primary, err = prh.singlePrimary(group); err != nil {
+		return state, err
+	}
+
+	conn := prh.connections[state.Primary]
+
+	names, err := conn.SyncStandbyNames(context.Background())
+	if err != nil {
+		return state, err
+	}
+
+	clients, err := conn.ReplicationClients(context.Background())
+	if err != nil {
+		return state, err
+	}
+
+	synchronous := names.Synchronous(clients)
+	state.Required, state.Connected, state.Standbys = names.Num, len(synchronous), []string{}
+
+	for member := range prh.connections.FilteredConnections(prh.config.GroupHosts(group)) {
+		node := routeNodeReplication{name: member, addresses: prh.nodeAddresses(member)}
+
+		for _, client := range synchronous {
+			if prh.isClient(client, node) {
+				state.Standbys = append(state.Standbys, member)
+
+				break
+			}
+		}
+	}
+
+	sort.Strings(state.Standbys)
+
+	return state, nil
+}
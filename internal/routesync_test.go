@@ -0,0 +1,15 @@
+package internal
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routesync", func() {
+	Context("checking synchronous standbys", func() {
+		It("should only be satisfied with enough synchronous standbys", func() {
+			Expect(RouteSyncState{Primary: "host1", Required: 1, Connected: 1}.Error()).To(Succeed())
+			Expect(RouteSyncState{Primary: "host1", Required: 2, Connected: 1}.Error()).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"context"
+	"slices"
+
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+)
+
+/*
+ * This module tracks the timeline of all nodes, to detect former primaries that diverged from the current primary.
+ * Such nodes need to be rebuilt (pg_rewind or a new basebackup), and are never offered for reads.
+ * Timelines are cached per group for cache_interval, as they are checked for every routing answer.
+ */
+
+// RouteNodeTimeline is the timeline of a node, and whether it diverged from the timeline of the primary
+type RouteNodeTimeline struct {
+	Timeline     int64 `json:"timeline" yaml:"timeline"`
+	NeedsRebuild bool  `json:"needs_rebuild" yaml:"needs_rebuild"`
+}
+
+// routeTimeline is the timeline of a primary, with its history when it could be read
+type routeTimeline struct {
+	timeline     int64
+	history      []pg.TimelineSwitch
+	historyKnown bool
+}
+
+// needsRebuild returns whether a standby on timeline, which replayed up to lsn, diverged from the primary:
+//   - a standby on a newer timeline than the primary diverged
+//   - a standby on an older timeline diverged when it replayed beyond the point where the primary switched
+//     timeline, or when that timeline is not in the history of the primary
+//   - without history, a standby on an older timeline is expected to need a rebuild when it does not stream
+func (rt routeTimeline) needsRebuild(timeline int64, lsn int64, orphaned bool) bool {
+	switch {
+	case timeline > rt.timeline:
+		return true
+	case timeline == rt.timeline:
+		return false
+	case !rt.historyKnown:
+		return orphaned
+	}
+
+	index := slices.IndexFunc(rt.history, func(ts pg.TimelineSwitch) bool { return ts.Timeline == timeline })
+	if index < 0 {
+		return true
+	}
+
+	return lsn > rt.history[index].LSN
+}
+
+// primaryTimeline returns the timeline of the primary of a group, or timeline 0 when there is not exactly one primary
+func (prh PgRouteHandler) primaryTimeline(group string) (rt routeTimeline) {
+	primaries := prh.GetPrimaries(group)
+	if len(primaries) != 1 {
+		return rt
+	}
+
+	conn := prh.connections[primaries[0]]
+
+	timeline, err := conn.Timeline(context.Background())
+	if err != nil {
+		prh.log.Debugf("Could not get timeline of primary %s, %s", primaries[0], err.Error())
+
+		return rt
+	}
+
+	rt.timeline = timeline
+	if !prh.config.ReadTimelineHistory {
+		return rt
+	}
+
+	if rt.history, err = conn.TimelineHistory(context.Background(), timeline); err != nil {
+		prh.log.Debugf("Could not read timeline history of primary %s, %s", primaries[0], err.Error())
+	} else {
+		rt.historyKnown = true
+	}
+
+	return rt
+}
+
+// nodeTimeline returns the timeline of a node, and whether it diverged from the primary
+func (prh PgRouteHandler) nodeTimeline(name string, primary routeTimeline) (RouteNodeTimeline, error) {
+	conn := prh.connections[name]
+
+	timeline, err := conn.Timeline(context.Background())
+	if err != nil {
+		return RouteNodeTimeline{}, err
+	}
+
+	nodeTimeline := RouteNodeTimeline{Timeline: timeline}
+
+	isStandby, err := conn.IsStandby(context.Background())
+	// without primary (timeline 0) there is nothing to diverge from
+	if err != nil || !isStandby || primary.timeline == 0 || timeline == primary.timeline {
+		return nodeTimeline, err
+	}
+
+	lsn, err := conn.ReplayLSN(context.Background())
+	if err != nil {
+		return nodeTimeline, err
+	}
+
+	nodeTimeline.NeedsRebuild = primary.needsRebuild(timeline, lsn, prh.isOrphaned(name))
+
+	return nodeTimeline, nil
+}
+
+// GetTimelines returns the timeline of all available members of a group, and whether they need to be rebuilt.
+// Without a single primary, no node is marked as needing a rebuild.
+// The result is cached for cache_interval, and must not be modified.
+func (prh PgRouteHandler) GetTimelines(group string) map[string]RouteNodeTimeline {
+	if timelines, cached := prh.timelines.get(group, prh.config.StateCacheInterval()); cached {
+		return timelines
+	}
+
+	primary := prh.primaryTimeline(group)
+
+	timelines := map[string]RouteNodeTimeline{}
+	for name := range prh.connections.FilteredConnections(prh.config.GroupHosts(group)) {
+		nodeTimeline, err := prh.nodeTimeline(name, primary)
+		if err != nil {
+			prh.log.Debugf("Could not get timeline of node %s, %s", name, err.Error())
+
+			continue
+		}
+
+		timelines[name] = nodeTimeline
+	}
+
+	prh.timelines.set(group, timelines)
+
+	return timelines
+}
+
+// needsRebuild returns all members of a group which diverged from the timeline of the primary
+func (prh PgRouteHandler) needsRebuild(group string) (names []string) {
+	for name, nodeTimeline := range prh.GetTimelines(group) {
+		if nodeTimeline.NeedsRebuild {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// withoutRebuilds returns nodes, leaving out the nodes that need to be rebuilt
+func (prh PgRouteHandler) withoutRebuilds(group string, nodes []string) []string {
+	if len(nodes) == 0 {
+		return nodes
+	}
+
+	rebuild := prh.needsRebuild(group)
+
+	return slices.DeleteFunc(nodes, func(name string) bool {
+		if slices.Contains(rebuild, name) {
+			prh.log.Debugf("Leaving out node %s, which needs to be rebuilt", name)
+
+			return true
+		}
+
+		return false
+	})
+}
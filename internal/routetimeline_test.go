@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routetimeline", func() {
+	history, err := pg.ParseTimelineHistory("1\t0/3000060\tno recovery target specified\n\n" +
+		"2\t0/5000000\tno recovery target specified\n")
+	Context("parsing timeline history", func() {
+		It("should parse every switch", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(Equal([]pg.TimelineSwitch{{Timeline: 1, LSN: 0x3000060}, {Timeline: 2, LSN: 0x5000000}}))
+		})
+	})
+	Context("detecting diverged nodes", func() {
+		primary := routeTimeline{timeline: 3, history: history, historyKnown: true}
+		It("should not flag nodes on the timeline of the primary", func() {
+			Expect(primary.needsRebuild(3, 0x9000000, true)).To(BeFalse())
+		})
+		It("should flag nodes on a newer timeline", func() {
+			Expect(primary.needsRebuild(4, 0, false)).To(BeTrue())
+		})
+		It("should flag nodes that replayed beyond the switch point of their timeline", func() {
+			Expect(primary.needsRebuild(2, 0x5000000, false)).To(BeFalse())
+			Expect(primary.needsRebuild(2, 0x5000001, false)).To(BeTrue())
+			Expect(primary.needsRebuild(1, 0x4000000, false)).To(BeTrue())
+		})
+		It("should only flag nodes on an older timeline that do not stream without history", func() {
+			withoutHistory := routeTimeline{timeline: 3}
+			Expect(withoutHistory.needsRebuild(2, 0x9000000, false)).To(BeFalse())
+			Expect(withoutHistory.needsRebuild(2, 0x9000000, true)).To(BeTrue())
+		})
+	})
+	Context("caching timelines", func() {
+		timelines := map[string]RouteNodeTimeline{"host1": {Timeline: 3}}
+		It("should return timelines until they expire", func() {
+			cache := newRouteGroupCache[map[string]RouteNodeTimeline]()
+			_, cached := cache.get("cluster", time.Minute)
+			Expect(cached).To(BeFalse())
+			cache.set("cluster", timelines)
+			cachedTimelines, cached := cache.get("cluster", time.Minute)
+			Expect(cached).To(BeTrue())
+			Expect(cachedTimelines).To(Equal(timelines))
+			_, cached = cache.get("cluster", 0)
+			Expect(cached).To(BeFalse())
+		})
+		It("should drop all timelines on reset", func() {
+			cache := newRouteGroupCache[map[string]RouteNodeTimeline]()
+			cache.set("cluster", timelines)
+			cache.reset()
+			_, cached := cache.get("cluster", time.Minute)
+			Expect(cached).To(BeFalse())
+		})
+		It("should serve cached timelines without querying nodes", func() {
+			handler := newTestHandler(RouteConfig{
+				Hosts:  RouteHostsConfig{"host1": {Dsn: pg.Dsn{"host": "10.0.0.1"}}},
+				Groups: RouteHostGroups{"cluster": {"host1"}},
+			})
+			handler.timelines.set("cluster", timelines)
+			Expect(handler.GetTimelines("cluster")).To(Equal(timelines))
+		})
+	})
+})
@@ -0,0 +1,320 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+)
+
+/*
+ * This module derives the replication topology of a group, including cascading standbys.
+ * The upstream of a standby is found from the sender host and port of its WAL receiver,
+ * or else from the pg_stat_replication rows of the other nodes (by application_name, client hostname or address).
+ * Cross-checking both views also reveals standbys that lost their upstream, and primaries without downstreams.
+ */
+
+const (
+	replicationPrimary                = "primary"
+	replicationPrimaryIsolated        = "primary-isolated"
+	replicationStandby                = "standby"
+	replicationStandbyOrphaned        = "standby-orphaned"
+	replicationStandbyUnknownUpstream = "standby-following-unknown-upstream"
+	walReceiverStreaming              = "streaming"
+)
+
+// RouteTreeNode is a node in the replication tree of a group, with all nodes that replicate from it as children
+type RouteTreeNode struct {
+	Name string `json:"name" yaml:"name"`
+	Role string `json:"role" yaml:"role"`
+	// Replication is the replication state of the node (e.g. standby-orphaned or primary-isolated)
+	Replication string `json:"replication" yaml:"replication"`
+	// Upstream is the node this node replicates from
+	Upstream string `json:"upstream,omitempty" yaml:"upstream,omitempty"`
+	// Sender is host:port the WAL receiver streams from, as reported by the node itself
+	Sender string `json:"sender,omitempty" yaml:"sender,omitempty"`
+	// State is the replication state as reported by the upstream, or else the status of the WAL receiver
+	State             string `json:"state,omitempty" yaml:"state,omitempty"`
+	SyncState         string `json:"sync_state,omitempty" yaml:"sync_state,omitempty"`
+	RouteNodeTimeline `yaml:",inline"`
+	Children          []*RouteTreeNode `json:"children" yaml:"children"`
+}
+
+// routeNodeReplication holds the replication state of a node, as seen from the node itself
+type routeNodeReplication struct {
+	name      string
+	role      string
+	receiver  *pg.WalReceiver
+	clients   []pg.ReplicationClient
+	addresses []netip.Addr
+}
+
+// sender returns host:port the WAL receiver streams from, or an empty string without WAL receiver
+func (rnr routeNodeReplication) sender() string {
+	if rnr.receiver == nil || rnr.receiver.SenderHost == "" {
+		return ""
+	}
+
+	return net.JoinHostPort(rnr.receiver.SenderHost, rnr.receiver.SenderPort)
+}
+
+// orphaned returns whether a node has no streaming WAL receiver
+func (rnr routeNodeReplication) orphaned() bool {
+	return rnr.receiver == nil || rnr.receiver.Status != walReceiverStreaming
+}
+
+// state returns the replication state of a node, given the number of members of its group and its upstream:
+//   - a standby is orphaned when its WAL receiver is not streaming,
+//     and follows an unknown upstream when it streams from a node outside of the group
+//   - a primary is isolated when no standby is connected, while the group has other members
+func (rnr routeNodeReplication) state(members int, upstream string) string {
+	switch {
+	case rnr.role == ghStatusPrimary && len(rnr.clients) == 0 && members > 1:
+		return replicationPrimaryIsolated
+	case rnr.role == ghStatusPrimary:
+		return replicationPrimary
+	case rnr.role != ghStatusStandby:
+		return rnr.role
+	case rnr.orphaned():
+		return replicationStandbyOrphaned
+	case upstream == "":
+		return replicationStandbyUnknownUpstream
+	default:
+		return replicationStandby
+	}
+}
+
+// nodeReplication collects the role, WAL receiver, and downstreams of a node
+func (prh PgRouteHandler) nodeReplication(name string) routeNodeReplication {
+	rnr := routeNodeReplication{name: name, role: prh.GetNodeStatus(name), addresses: prh.nodeAddresses(name)}
+	if rnr.role != ghStatusPrimary && rnr.role != ghStatusStandby {
+		return rnr
+	}
+
+	conn := prh.connections[name]
+
+	if receiver, exists, err := conn.WalReceiver(context.Background()); err != nil {
+		prh.log.Debugf("Could not get WAL receiver of node %s, %s", name, err.Error())
+	} else if exists {
+		rnr.receiver = &receiver
+	}
+
+	clients, err := conn.ReplicationClients(context.Background())
+	if err != nil {
+		prh.log.Debugf("Could not get replication clients of node %s, %s", name, err.Error())
+	}
+
+	rnr.clients = clients
+
+	return rnr
+}
+
+// groupReplication collects the replication state of all members of a group
+func (prh PgRouteHandler) groupReplication(group string) map[string]routeNodeReplication {
+	replication := map[string]routeNodeReplication{}
+	for name := range prh.connections.FilteredConnections(prh.config.GroupHosts(group)) {
+		replication[name] = prh.nodeReplication(name)
+	}
+
+	return replication
+}
+
+// isOrphaned returns whether a node is a standby without streaming WAL receiver
+func (prh PgRouteHandler) isOrphaned(name string) bool {
+	conn, exists := prh.connections[name]
+	if !exists {
+		return false
+	}
+
+	receiver, exists, err := conn.WalReceiver(context.Background())
+	if err != nil {
+		prh.log.Debugf("Could not get WAL receiver of node %s, %s", name, err.Error())
+
+		return false
+	}
+
+	return !exists || receiver.Status != walReceiverStreaming
+}
+
+// replicationStates returns the replication state of every member of a group
+func (prh PgRouteHandler) replicationStates(group string) map[string]string {
+	replication := prh.groupReplication(group)
+	upstreams := prh.upstreams(replication)
+
+	states := map[string]string{}
+	for name, node := range replication {
+		states[name] = node.state(len(replication), upstreams[name])
+	}
+
+	return states
+}
+
+// isSender returns whether a WAL receiver streams from a node
+func (prh PgRouteHandler) isSender(receiver *pg.WalReceiver, node routeNodeReplication) bool {
+	conn := prh.connections[node.name]
+	if receiver == nil || receiver.SenderHost == "" || receiver.SenderPort != conn.Port() {
+		return false
+	}
+
+	if receiver.SenderHost == conn.Host() {
+		return true
+	}
+
+	senderAddresses, err := lookupAddresses(receiver.SenderHost)
+	if err != nil {
+		prh.log.Debugf("could not resolve sender host %s: %s", receiver.SenderHost, err.Error())
+
+		return false
+	}
+
+	return slices.ContainsFunc(senderAddresses, func(addr netip.Addr) bool {
+		return slices.Contains(node.addresses, addr)
+	})
+}
+
+// isClient returns whether a row of pg_stat_replication belongs to a node
+func (prh PgRouteHandler) isClient(client pg.ReplicationClient, node routeNodeReplication) bool {
+	if client.ApplicationName == node.name || client.ClientHostname == prh.connections[node.name].Host() {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(client.ClientAddr)
+
+	return err == nil && slices.Contains(node.addresses, addr.Unmap())
+}
+
+// clientOf returns the row of pg_stat_replication of upstream that belongs to downstream
+func (prh PgRouteHandler) clientOf(upstream routeNodeReplication,
+	downstream routeNodeReplication,
+) (pg.ReplicationClient, bool) {
+	for _, client := range upstream.clients {
+		if prh.isClient(client, downstream) {
+			return client, true
+		}
+	}
+
+	return pg.ReplicationClient{}, false
+}
+
+// upstreamOf returns the node a node replicates from, or an empty string when it is unknown
+func (prh PgRouteHandler) upstreamOf(node routeNodeReplication, replication map[string]routeNodeReplication) string {
+	if node.role != ghStatusStandby {
+		return ""
+	}
+
+	var candidates []string
+	for name, other := range replication {
+		if name != node.name && prh.isSender(node.receiver, other) {
+			return name
+		}
+
+		if _, isClient := prh.clientOf(other, node); name != node.name && isClient {
+			candidates = append(candidates, name)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	sort.Strings(candidates)
+
+	return candidates[0]
+}
+
+// upstreams returns the upstream of every member of a group that replicates from another member.
+// Members are linked in order of name, so that the edge that would close a cycle is always the same one.
+func (prh PgRouteHandler) upstreams(replication map[string]routeNodeReplication) map[string]string {
+	upstreams := map[string]string{}
+
+	names := make([]string, 0, len(replication))
+	for name := range replication {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		upstream := prh.upstreamOf(replication[name], replication)
+		if upstream == "" {
+			continue
+		}
+
+		// never link a node below one of its own downstreams, so that the tree stays a tree
+		cyclic := false
+		for ancestor := upstream; ancestor != ""; ancestor = upstreams[ancestor] {
+			if ancestor == name {
+				cyclic = true
+
+				break
+			}
+		}
+
+		if !cyclic {
+			upstreams[name] = upstream
+		}
+	}
+
+	return upstreams
+}
+
+// GetTree returns the replication tree of a group: all nodes without (known) upstream, with their downstreams as
+// children
+func (prh PgRouteHandler) GetTree(group string) ([]*RouteTreeNode, error) {
+	if !prh.config.HasGroup(group) {
+		return nil, fmt.Errorf("group %s is not defined", group)
+	}
+
+	replication := prh.groupReplication(group)
+	upstreams := prh.upstreams(replication)
+	timelines := prh.GetTimelines(group)
+
+	nodes := map[string]*RouteTreeNode{}
+	for name, node := range replication {
+		nodes[name] = &RouteTreeNode{
+			Name:              name,
+			Role:              node.role,
+			Replication:       node.state(len(replication), upstreams[name]),
+			Upstream:          upstreams[name],
+			Sender:            node.sender(),
+			RouteNodeTimeline: timelines[name],
+			Children:          []*RouteTreeNode{},
+		}
+
+		if node.receiver != nil {
+			nodes[name].State = node.receiver.Status
+		}
+	}
+
+	roots := []*RouteTreeNode{}
+	for name, treeNode := range nodes {
+		if treeNode.Upstream == "" {
+			roots = append(roots, treeNode)
+
+			continue
+		}
+
+		if client, exists := prh.clientOf(replication[treeNode.Upstream], replication[name]); exists {
+			treeNode.State, treeNode.SyncState = client.State, client.SyncState
+		}
+
+		upstream := nodes[treeNode.Upstream]
+		upstream.Children = append(upstream.Children, treeNode)
+	}
+
+	sortTree(roots)
+
+	return roots, nil
+}
+
+func sortTree(nodes []*RouteTreeNode) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+
+	for _, node := range nodes {
+		sortTree(node.Children)
+	}
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routetree", func() {
+	streaming := &pg.WalReceiver{Status: walReceiverStreaming, SenderHost: "host1", SenderPort: "5432"}
+	Context("replication states", func() {
+		It("should report standbys without streaming WAL receiver as orphaned", func() {
+			for _, receiver := range []*pg.WalReceiver{nil, {Status: "waiting"}} {
+				node := routeNodeReplication{name: "host2", role: ghStatusStandby, receiver: receiver}
+				Expect(node.state(3, "")).To(Equal(replicationStandbyOrphaned))
+			}
+		})
+		It("should report standbys streaming from outside the group", func() {
+			node := routeNodeReplication{name: "host2", role: ghStatusStandby, receiver: streaming}
+			Expect(node.state(3, "")).To(Equal(replicationStandbyUnknownUpstream))
+			Expect(node.state(3, "host1")).To(Equal(replicationStandby))
+		})
+		It("should report primaries without standbys as isolated, unless they are the only member", func() {
+			node := routeNodeReplication{name: "host1", role: ghStatusPrimary}
+			Expect(node.state(3, "")).To(Equal(replicationPrimaryIsolated))
+			Expect(node.state(1, "")).To(Equal(replicationPrimary))
+			node.clients = []pg.ReplicationClient{{ApplicationName: "host2"}}
+			Expect(node.state(3, "")).To(Equal(replicationPrimary))
+		})
+		It("should report unavailable nodes as such", func() {
+			node := routeNodeReplication{name: "host3", role: ghStatusUnavailable}
+			Expect(node.state(3, "")).To(Equal(ghStatusUnavailable))
+		})
+	})
+	Context("upstreams", func() {
+		It("should always drop the same edge of a replication cycle", func() {
+			handler := newTestHandler(RouteConfig{Hosts: RouteHostsConfig{
+				"host1": {Dsn: pg.Dsn{"host": "10.0.0.1", "port": "5432"}},
+				"host2": {Dsn: pg.Dsn{"host": "10.0.0.2", "port": "5432"}},
+				"host3": {Dsn: pg.Dsn{"host": "10.0.0.3", "port": "5432"}},
+			}})
+			replication := map[string]routeNodeReplication{}
+			for name, client := range map[string]string{"host1": "host2", "host2": "host3", "host3": "host1"} {
+				replication[name] = routeNodeReplication{
+					name:    name,
+					role:    ghStatusStandby,
+					clients: []pg.ReplicationClient{{ApplicationName: client}},
+				}
+			}
+			for range 20 {
+				Expect(handler.upstreams(replication)).To(Equal(map[string]string{"host1": "host3", "host2": "host1"}))
+			}
+		})
+	})
+})
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+)
+
+const (
+	strategyRoundRobin = "round-robin"
+	strategyRandom     = "random"
+	strategyLeastLag   = "least-lag"
+	strategyWeighted   = "weighted"
+)
+
+// standbyLags returns the replication lag (in bytes) of every standby.
+// Lag is measured against the primary, or against the most recent standby when there is no (single) primary.
+func (prh PgRouteHandler) standbyLags(group string, standbys []string) map[string]int64 {
+	replayed := map[string]int64{}
+
+	var reference int64
+
+	for _, name := range standbys {
+		lsn, err := prh.connections[name].ReplayLSN(context.Background())
+		if err != nil {
+			prh.log.Debugf("Could not get replay lsn of standby %s, %s", name, err.Error())
+
+			continue
+		}
+
+		replayed[name] = lsn
+		reference = max(reference, lsn)
+	}
+
+	if primaries := prh.GetPrimaries(group); len(primaries) == 1 {
+		if lsn, err := prh.connections[primaries[0]].CurrentLSN(context.Background()); err != nil {
+			prh.log.Debugf("Could not get current lsn of primary %s, %s", primaries[0], err.Error())
+		} else {
+			reference = max(reference, lsn)
+		}
+	}
+
+	lags := map[string]int64{}
+	for name, lsn := range replayed {
+		lags[name] = reference - lsn
+	}
+
+	return lags
+}
+
+func (prh PgRouteHandler) leastLagStandby(group string, standbys []string) string {
+	return leastLag(standbys, prh.standbyLags(group, standbys))
+}
+
+// leastLag returns the standby with the least lag, leaving out standbys with unknown lag
+func leastLag(standbys []string, lags map[string]int64) string {
+	var selected string
+
+	// standbys is sorted, so on equal lag the first by name is selected
+	for _, name := range standbys {
+		lag, known := lags[name]
+		if known && (selected == "" || lag < lags[selected]) {
+			selected = name
+		}
+	}
+
+	return selected
+}
+
+func (prh PgRouteHandler) weightedStandby(standbys []string) string {
+	var total int
+	for _, name := range standbys {
+		total += prh.config.Hosts[name].HostWeight()
+	}
+
+	// #nosec G404 -- load balancing does not require a cryptographically secure random
+	pick := rand.IntN(total)
+	for _, name := range standbys {
+		if pick -= prh.config.Hosts[name].HostWeight(); pick < 0 {
+			return name
+		}
+	}
+
+	return standbys[len(standbys)-1]
+}
+
+// loadBalancedStandbys returns all standbys of a group, except for the standbys tagged noloadbalance
+func (prh PgRouteHandler) loadBalancedStandbys(group string) []string {
+	return slices.DeleteFunc(prh.GetStandbys(group), func(name string) bool {
+		return prh.config.Hosts[name].Tags.Has(tagNoLoadBalance)
+	})
+}
+
+// SelectStandby returns one standby of a group that passes the filter, selected with a load balancing strategy.
+// Standbys tagged noloadbalance are never selected.
+// When no standby qualifies and fallbackPrimary is set, the primary is returned instead (if there is exactly one).
+func (prh PgRouteHandler) SelectStandby(
+	group string,
+	strategy string,
+	filter RouteNodeFilter,
+	fallbackPrimary bool,
+) (string, error) {
+	strategies := []string{strategyRoundRobin, strategyRandom, strategyLeastLag, strategyWeighted}
+	if !slices.Contains(strategies, strategy) {
+		return "", fmt.Errorf("invalid strategy %s (should be one of %v)", strategy, strategies)
+	}
+
+	standbys := filter.Apply(prh.config.Hosts, prh.loadBalancedStandbys(group))
+	if selected := prh.pickStandby(group, strategy, standbys); selected != "" || !fallbackPrimary {
+		return selected, nil
+	}
+
+	return prh.primaryFallback(filter, prh.GetPrimaries(group)), nil
+}
+
+// pickStandby selects one of standbys with a load balancing strategy
+func (prh PgRouteHandler) pickStandby(group string, strategy string, standbys []string) (selected string) {
+	if len(standbys) == 0 {
+		return ""
+	}
+
+	switch strategy {
+	case strategyRoundRobin:
+		selected, _ = prh.standbyBalancer.pick(standbys)
+	case strategyRandom:
+		// #nosec G404 -- load balancing does not require a cryptographically secure random
+		selected = standbys[rand.IntN(len(standbys))]
+	case strategyLeastLag:
+		selected = prh.leastLagStandby(group, standbys)
+	case strategyWeighted:
+		selected = prh.weightedStandby(standbys)
+	}
+
+	return selected
+}
+
+// primaryFallback returns the primary when there is exactly one, and it has the tags of the filter
+func (prh PgRouteHandler) primaryFallback(filter RouteNodeFilter, primaries []string) string {
+	if len(primaries) == 1 && len(RouteNodeFilter{Tags: filter.Tags}.Apply(prh.config.Hosts, primaries)) == 1 {
+		return primaries[0]
+	}
+
+	return ""
+}
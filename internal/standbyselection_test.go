@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Standbyselection", func() {
+	var handler *PgRouteHandler
+	standbys := []string{"host2", "host3"}
+	BeforeEach(func() {
+		handler = newTestHandler(RouteConfig{
+			Hosts: RouteHostsConfig{
+				"host1": {Dsn: pg.Dsn{"host": "127.0.0.1", "port": "1", "connect_timeout": "1"},
+					Tags: RouteHostTags{"zone": "dc1"}},
+				"host2": {Dsn: pg.Dsn{"host": "127.0.0.1", "port": "1", "connect_timeout": "1"},
+					Tags: RouteHostTags{"zone": "dc2"}},
+				"host3": {Dsn: pg.Dsn{"host": "127.0.0.1", "port": "1", "connect_timeout": "1"},
+					Tags: RouteHostTags{"zone": "dc2"}, Weight: 999},
+			},
+			Groups: RouteHostGroups{"cluster": {"host1", "host2", "host3"}},
+		})
+	})
+	Context("selecting a standby", func() {
+		It("should refuse an unknown strategy", func() {
+			_, err := handler.SelectStandby("cluster", "fastest", RouteNodeFilter{}, true)
+			Expect(err).To(MatchError(ContainSubstring("invalid strategy fastest")))
+		})
+		It("should select nothing when no node is available", func() {
+			for _, strategy := range []string{strategyRoundRobin, strategyRandom, strategyLeastLag, strategyWeighted} {
+				Expect(handler.SelectStandby("cluster", strategy, RouteNodeFilter{}, true)).To(BeEmpty())
+			}
+		})
+		It("should select nothing from an empty list", func() {
+			Expect(handler.pickStandby("cluster", strategyRoundRobin, nil)).To(BeEmpty())
+			Expect(handler.pickStandby("cluster", strategyWeighted, nil)).To(BeEmpty())
+		})
+		It("should rotate over the standbys", func() {
+			first := handler.pickStandby("cluster", strategyRoundRobin, standbys)
+			second := handler.pickStandby("cluster", strategyRoundRobin, standbys)
+			Expect([]string{first, second}).To(ConsistOf(standbys))
+			Expect(handler.pickStandby("cluster", strategyRoundRobin, standbys)).To(Equal(first))
+		})
+		It("should only select from the standbys", func() {
+			for range 10 {
+				Expect(handler.pickStandby("cluster", strategyRandom, standbys)).To(BeElementOf(standbys))
+			}
+		})
+	})
+	Context("weighing standbys", func() {
+		It("should select the only standby", func() {
+			Expect(handler.weightedStandby([]string{"host2"})).To(Equal("host2"))
+		})
+		It("should mostly select the standby with the highest weight", func() {
+			picked := map[string]int{}
+			for range 1000 {
+				picked[handler.weightedStandby(standbys)]++
+			}
+			Expect(picked["host3"]).To(BeNumerically(">", 900))
+		})
+	})
+	Context("least lag", func() {
+		It("should select the standby with the least lag", func() {
+			Expect(leastLag(standbys, map[string]int64{"host2": 100, "host3": 10})).To(Equal("host3"))
+		})
+		It("should select the first by name on equal lag", func() {
+			Expect(leastLag(standbys, map[string]int64{"host2": 10, "host3": 10})).To(Equal("host2"))
+		})
+		It("should leave out standbys with unknown lag", func() {
+			Expect(leastLag(standbys, map[string]int64{"host3": 1000})).To(Equal("host3"))
+			Expect(leastLag(standbys, map[string]int64{})).To(BeEmpty())
+		})
+	})
+	Context("falling back to the primary", func() {
+		It("should return the only primary", func() {
+			Expect(handler.primaryFallback(RouteNodeFilter{}, []string{"host1"})).To(Equal("host1"))
+		})
+		It("should not return a primary in split brain", func() {
+			Expect(handler.primaryFallback(RouteNodeFilter{}, []string{"host1", "host2"})).To(BeEmpty())
+			Expect(handler.primaryFallback(RouteNodeFilter{}, nil)).To(BeEmpty())
+		})
+		It("should only return a primary with the tags of the filter", func() {
+			Expect(handler.primaryFallback(NewRouteNodeFilter([]string{"zone:dc2"}, nil), []string{"host1"})).
+				To(BeEmpty())
+		})
+		It("should ignore preferred tags", func() {
+			Expect(handler.primaryFallback(NewRouteNodeFilter(nil, []string{"zone:dc2"}), []string{"host1"})).
+				To(Equal("host1"))
+		})
+	})
+})
@@ -0,0 +1,317 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+ * This module switches the primary of a group over to a standby:
+ *   - the target must be a healthy standby of the group, lagging at most max_lag bytes behind the primary
+ *   - the primary is fenced: it is set to default_transaction_read_only, and left out of all routing answers
+ *   - when no backend of the primary holds a write transaction anymore,
+ *     and the target replayed all WAL of the primary, the target is promoted with pg_promote()
+ * The old primary stays fenced (left out of routing answers) until it runs as a standby (after a rebuild).
+ * A primary that is read-only by default_transaction_read_only is always treated as fenced,
+ * also after a restart of pgroute66, or by other instances.
+ * When the target does not catch up in time, the primary is unfenced again.
+ */
+
+const (
+	switchoverStepOk      = "ok"
+	switchoverStepFailed  = "failed"
+	switchoverStepPlanned = "planned"
+	switchoverPoll        = 100 * time.Millisecond
+)
+
+// errSwitchoverRejected is returned when a precondition of a switchover is not met, and nothing was changed
+var errSwitchoverRejected = errors.New("switchover rejected")
+
+// lockedSet is a set of names (like the fenced nodes, or the groups in maintenance), safe for concurrent use
+type lockedSet struct {
+	mutex sync.Mutex
+	names map[string]bool
+}
+
+func newLockedSet() *lockedSet {
+	return &lockedSet{names: map[string]bool{}}
+}
+
+func (ls *lockedSet) add(name string) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	ls.names[name] = true
+}
+
+func (ls *lockedSet) remove(name string) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	delete(ls.names, name)
+}
+
+func (ls *lockedSet) contains(name string) bool {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	return ls.names[name]
+}
+
+// RouteSwitchoverStep is a step of a switchover, with its status (ok, failed, or planned for a dry run)
+type RouteSwitchoverStep struct {
+	Step    string `json:"step" yaml:"step"`
+	Status  string `json:"status" yaml:"status"`
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+// RouteSwitchover reports all steps of a switchover
+type RouteSwitchover struct {
+	Group   string                `json:"group" yaml:"group"`
+	Primary string                `json:"primary" yaml:"primary"`
+	Target  string                `json:"target" yaml:"target"`
+	DryRun  bool                  `json:"dry_run" yaml:"dry_run"`
+	Steps   []RouteSwitchoverStep `json:"steps" yaml:"steps"`
+}
+
+// check adds a step that checks a precondition, and returns err wrapped as a rejection
+func (rs *RouteSwitchover) check(step string, message string, err error) error {
+	if err != nil {
+		rs.Steps = append(rs.Steps, RouteSwitchoverStep{Step: step, Status: switchoverStepFailed, Message: err.Error()})
+
+		return fmt.Errorf("%w: %w", errSwitchoverRejected, err)
+	}
+
+	rs.Steps = append(rs.Steps, RouteSwitchoverStep{Step: step, Status: switchoverStepOk, Message: message})
+
+	return nil
+}
+
+// run adds a step that changes the cluster, which is only planned for a dry run
+func (rs *RouteSwitchover) run(step string, action func() (string, error)) error {
+	if rs.DryRun {
+		rs.Steps = append(rs.Steps, RouteSwitchoverStep{Step: step, Status: switchoverStepPlanned})
+
+		return nil
+	}
+
+	message, err := action()
+	if err != nil {
+		rs.Steps = append(rs.Steps, RouteSwitchoverStep{Step: step, Status: switchoverStepFailed, Message: err.Error()})
+
+		return fmt.Errorf("%s failed: %w", step, err)
+	}
+
+	rs.Steps = append(rs.Steps, RouteSwitchoverStep{Step: step, Status: switchoverStepOk, Message: message})
+
+	return nil
+}
+
+// checkTarget returns an error when the target is not a standby of the group that can be promoted
+func (prh PgRouteHandler) checkTarget(group string, target string) error {
+	conn, exists := prh.connections[target]
+
+	switch {
+	case !exists || !slices.Contains(prh.config.GroupHosts(group), target):
+		return fmt.Errorf("node %s is not a member of group %s", target, group)
+	case slices.Contains(prh.needsRebuild(group), target):
+		return fmt.Errorf("node %s needs to be rebuilt", target)
+	}
+
+	isStandby, err := conn.IsStandby(context.Background())
+	if err != nil {
+		return err
+	} else if !isStandby {
+		return fmt.Errorf("node %s is not a standby", target)
+	}
+
+	return nil
+}
+
+// targetLag returns how many bytes the target lags behind the primary
+func (prh PgRouteHandler) targetLag(primary string, target string) (int64, error) {
+	primaryLSN, err := prh.connections[primary].CurrentLSN(context.Background())
+	if err != nil {
+		return 0, err
+	}
+
+	targetLSN, err := prh.connections[target].ReplayLSN(context.Background())
+	if err != nil {
+		return 0, err
+	}
+
+	return primaryLSN - targetLSN, nil
+}
+
+// waitForWriters waits until no backend of the (fenced) primary holds a write transaction,
+// as those could still commit after the final WAL location is read
+func (prh PgRouteHandler) waitForWriters(primary string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(switchoverPoll)
+	defer ticker.Stop()
+
+	for {
+		pids, err := prh.connections[primary].WriteTransactions(ctx)
+		if err != nil {
+			return "", err
+		} else if len(pids) == 0 {
+			return "no write transactions", nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("backends %v still hold a write transaction after %s", pids, timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitForReplay waits until the target replayed all WAL of the (fenced) primary
+func (prh PgRouteHandler) waitForReplay(primary string, target string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	finalLSN, err := prh.connections[primary].CurrentLSN(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	ticker := time.NewTicker(switchoverPoll)
+	defer ticker.Stop()
+
+	for {
+		replayed, err := prh.connections[target].ReplayLSN(ctx)
+		if err != nil {
+			return "", err
+		} else if replayed >= finalLSN {
+			return fmt.Sprintf("replayed up to %d", replayed), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("replayed up to %d of %d within %s", replayed, finalLSN, timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// fence sets a primary to default_transaction_read_only, and leaves it out of routing answers
+func (prh PgRouteHandler) fence(name string) error {
+	if err := prh.connections[name].SetReadOnly(context.Background(), true); err != nil {
+		return err
+	}
+
+	prh.fenced.add(name)
+
+	return nil
+}
+
+// unfence adds a primary to routing answers again, and sets it to read-write again.
+// The primary is always added again, as it would not be routed to at all when it stays read-only by accident.
+func (prh PgRouteHandler) unfence(name string) error {
+	prh.fenced.remove(name)
+
+	err := prh.connections[name].SetReadOnly(context.Background(), false)
+	if err != nil {
+		prh.log.Errorf("failed to set node %s to read-write again: %s", name, err.Error())
+	}
+
+	return err
+}
+
+// promote promotes a standby, and resets default_transaction_read_only in case it was fenced as primary before
+func (prh PgRouteHandler) promote(name string, timeout time.Duration) error {
+	defer prh.timelines.reset()
+
+	conn := prh.connections[name]
+	if err := conn.Promote(context.Background(), timeout); err != nil {
+		return err
+	}
+
+	return conn.SetReadOnly(context.Background(), false)
+}
+
+// routeSwitchoverActions are the actions of a switchover on the nodes of a group
+type routeSwitchoverActions interface {
+	fence(name string) error
+	unfence(name string) error
+	waitForWriters(primary string, timeout time.Duration) (string, error)
+	waitForReplay(primary string, target string, timeout time.Duration) (string, error)
+	promote(name string, timeout time.Duration) error
+}
+
+// switchover runs all steps that change the cluster, from fencing the primary to promoting the target.
+// When a step before promotion fails, the primary is unfenced again.
+func (rs *RouteSwitchover) switchover(actions routeSwitchoverActions, timeout time.Duration) error {
+	unfence := func(err error) error {
+		_ = rs.run("unfence primary", func() (string, error) { return "", actions.unfence(rs.Primary) })
+
+		return err
+	}
+
+	if err := rs.run("fence primary", func() (string, error) { return "", actions.fence(rs.Primary) }); err != nil {
+		return unfence(err)
+	}
+
+	if err := rs.run("wait for writers", func() (string, error) {
+		return actions.waitForWriters(rs.Primary, timeout)
+	}); err != nil {
+		return unfence(err)
+	}
+
+	if err := rs.run("wait for replay", func() (string, error) {
+		return actions.waitForReplay(rs.Primary, rs.Target, timeout)
+	}); err != nil {
+		return unfence(err)
+	}
+
+	// without promotion, the primary stays fenced, as the target might still complete promotion
+	return rs.run("promote target", func() (string, error) { return "", actions.promote(rs.Target, timeout) })
+}
+
+// Switchover switches the primary of a group over to target. A dry run only checks the preconditions.
+// The returned report holds every step, also when an error is returned.
+func (prh PgRouteHandler) Switchover(group string, target string, dryRun bool) (report RouteSwitchover, err error) {
+	report = RouteSwitchover{Group: group, Target: target, DryRun: dryRun, Steps: []RouteSwitchoverStep{}}
+
+	if !prh.topologyMutex.TryLock() {
+		return report, report.check("lock", "", errors.New("another switchover or failover is running"))
+	}
+	defer prh.topologyMutex.Unlock()
+
+	primary, err := prh.singlePrimary(group)
+	if err = report.check("check primary", primary, err); err != nil {
+		return report, err
+	}
+
+	report.Primary = primary
+	if err = report.check("check target", "", prh.checkTarget(group, target)); err != nil {
+		return report, err
+	}
+
+	lag, err := prh.targetLag(primary, target)
+	if err == nil && lag > prh.config.Switchover.SwitchoverMaxLag() {
+		err = fmt.Errorf("node %s lags %d bytes behind (max_lag is %d)", target, lag,
+			prh.config.Switchover.SwitchoverMaxLag())
+	}
+
+	if err = report.check("check lag", fmt.Sprintf("%d bytes", lag), err); err != nil {
+		return report, err
+	}
+
+	if err = report.switchover(prh, prh.config.Switchover.SwitchoverTimeout()); err != nil {
+		return report, err
+	}
+
+	if !dryRun {
+		prh.log.Infof("switched over group %s from %s to %s", group, primary, target)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mannemsolutions/pgroute66/pkg/pg"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Switchover", func() {
+	Context("report", func() {
+		It("should reject on a failed check", func() {
+			report := RouteSwitchover{}
+			Expect(report.check("check primary", "host1", nil)).To(Succeed())
+			err := report.check("check target", "", errors.New("node host3 is not a standby"))
+			Expect(errors.Is(err, errSwitchoverRejected)).To(BeTrue())
+			Expect(report.Steps).To(Equal([]RouteSwitchoverStep{
+				{Step: "check primary", Status: switchoverStepOk, Message: "host1"},
+				{Step: "check target", Status: switchoverStepFailed, Message: "node host3 is not a standby"},
+			}))
+		})
+		It("should only plan steps for a dry run", func() {
+			report := RouteSwitchover{DryRun: true}
+			Expect(report.run("promote target", func() (string, error) {
+				Fail("dry run should not run steps")
+
+				return "", nil
+			})).To(Succeed())
+			Expect(report.Steps).To(Equal([]RouteSwitchoverStep{{Step: "promote target", Status: switchoverStepPlanned}}))
+		})
+		It("should report a failed step", func() {
+			report := RouteSwitchover{}
+			err := report.run("wait for replay", func() (string, error) { return "", errors.New("timeout") })
+			Expect(err).To(MatchError("wait for replay failed: timeout"))
+			Expect(errors.Is(err, errSwitchoverRejected)).To(BeFalse())
+			Expect(report.Steps[0].Status).To(Equal(switchoverStepFailed))
+		})
+	})
+	Context("locked set", func() {
+		It("should add and remove names", func() {
+			fenced := newLockedSet()
+			fenced.add("host1")
+			Expect(fenced.contains("host1")).To(BeTrue())
+			fenced.remove("host1")
+			Expect(fenced.contains("host1")).To(BeFalse())
+		})
+	})
+	Context("steps", func() {
+		It("should unfence the primary when fencing fails", func() {
+			actions := &fakeSwitchoverActions{failing: map[string]error{"fence": errors.New("permission denied")}}
+			report := RouteSwitchover{Primary: "host1", Target: "host2"}
+			Expect(report.switchover(actions, time.Second)).To(MatchError("fence primary failed: permission denied"))
+			Expect(actions.calls).To(Equal([]string{"fence host1", "unfence host1"}))
+			Expect(report.Steps).To(Equal([]RouteSwitchoverStep{
+				{Step: "fence primary", Status: switchoverStepFailed, Message: "permission denied"},
+				{Step: "unfence primary", Status: switchoverStepOk},
+			}))
+		})
+		It("should report a failing unfence, and return the error of the step that failed", func() {
+			actions := &fakeSwitchoverActions{failing: map[string]error{
+				"waitForWriters": errors.New("backends [42] still hold a write transaction after 1s"),
+				"unfence":        errors.New("connection refused"),
+			}}
+			report := RouteSwitchover{Primary: "host1", Target: "host2"}
+			Expect(report.switchover(actions, time.Second)).To(MatchError(
+				"wait for writers failed: backends [42] still hold a write transaction after 1s"))
+			Expect(actions.calls).To(Equal([]string{"fence host1", "waitForWriters host1", "unfence host1"}))
+			Expect(report.Steps[2]).To(Equal(RouteSwitchoverStep{Step: "unfence primary", Status: switchoverStepFailed,
+				Message: "connection refused"}))
+		})
+		It("should promote the target without unfencing the primary", func() {
+			actions := &fakeSwitchoverActions{}
+			report := RouteSwitchover{Primary: "host1", Target: "host2"}
+			Expect(report.switchover(actions, time.Second)).To(Succeed())
+			Expect(actions.calls).To(Equal([]string{"fence host1", "waitForWriters host1", "waitForReplay host1 host2",
+				"promote host2"}))
+		})
+		It("should not act on a dry run", func() {
+			actions := &fakeSwitchoverActions{}
+			report := RouteSwitchover{Primary: "host1", Target: "host2", DryRun: true}
+			Expect(report.switchover(actions, time.Second)).To(Succeed())
+			Expect(actions.calls).To(BeEmpty())
+			Expect(report.Steps).To(HaveLen(4))
+		})
+	})
+	Context("fencing", func() {
+		var handler *PgRouteHandler
+		BeforeEach(func() {
+			// nothing listens on port 1, so that every query fails
+			handler = newTestHandler(RouteConfig{Hosts: RouteHostsConfig{
+				"host1": {Dsn: pg.Dsn{"host": "127.0.0.1", "port": "1", "connect_timeout": "1"}},
+			}})
+		})
+		It("should only leave a primary out of routing answers when it is read-only", func() {
+			Expect(handler.fence("host1")).NotTo(Succeed())
+			Expect(handler.fenced.contains("host1")).To(BeFalse())
+		})
+		It("should always add a primary to routing answers again", func() {
+			handler.fenced.add("host1")
+			Expect(handler.unfence("host1")).NotTo(Succeed())
+			Expect(handler.fenced.contains("host1")).To(BeFalse())
+		})
+	})
+})
+
+// fakeSwitchoverActions records the actions of a switchover, and fails the actions in failing
+type fakeSwitchoverActions struct {
+	failing map[string]error
+	calls   []string
+}
+
+func (fsa *fakeSwitchoverActions) act(action string, args ...string) error {
+	fsa.calls = append(fsa.calls, strings.Join(append([]string{action}, args...), " "))
+
+	return fsa.failing[action]
+}
+
+func (fsa *fakeSwitchoverActions) fence(name string) error {
+	return fsa.act("fence", name)
+}
+
+func (fsa *fakeSwitchoverActions) unfence(name string) error {
+	return fsa.act("unfence", name)
+}
+
+func (fsa *fakeSwitchoverActions) waitForWriters(primary string, _ time.Duration) (string, error) {
+	return "", fsa.act("waitForWriters", primary)
+}
+
+func (fsa *fakeSwitchoverActions) waitForReplay(primary string, target string, _ time.Duration) (string, error) {
+	return "", fsa.act("waitForReplay", primary, target)
+}
+
+func (fsa *fakeSwitchoverActions) promote(name string, _ time.Duration) error {
+	return fsa.act("promote", name)
+}
@@ -3,137 +3,309 @@ package pg
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 const (
-	// AvcSchema is the schema to store the Availability Checker table
+	// AvcSchema is the default schema to store the Availability Checker table
 	AvcSchema = "public"
-	// AvcTable is the table to store the Availability Checker record
+	// AvcTable is the default table to store the Availability Checker record
 	AvcTable = "pgr66_avc"
 	// AvcColumn is the column to store the Availability Checker data
 	AvcColumn = "pgr66_avc"
+	// AvcInstanceColumn is the column with the instance that a heartbeat row belongs to
+	AvcInstanceColumn = "instance_id"
+	// AvcBackendTable stores the heartbeat in a table
+	AvcBackendTable AvcBackend = "table"
+	// AvcBackendWAL writes the heartbeat as a message into WAL, and measures the time since the last replayed commit
+	AvcBackendWAL AvcBackend = "wal"
+	// AvcMessagePrefix is the prefix of heartbeat messages written into WAL
+	AvcMessagePrefix = "pgroute66"
+
+	sqlStateInsufficientPrivilege = "42501"
+	sqlStateUndefinedTable        = "42P01"
 )
 
-// AvcDurationExceededError is raised when AV check duration is expired
-type AvcDurationExceededError struct {
-	max      float64
-	actually float64
+var (
+	// ErrAvcTableMissing is returned when the Availability Checker table does not exist
+	ErrAvcTableMissing = errors.New("heartbeat table does not exist")
+	// ErrAvcNoHeartbeat is returned when a standby did not replay any heartbeat (WAL backend)
+	ErrAvcNoHeartbeat = errors.New("no heartbeat replayed")
+	// ErrAvcPermissionDenied is returned when the Availability Checker table cannot be created, read or written
+	ErrAvcPermissionDenied = errors.New("permission denied")
+)
+
+// AvcBackend defines how the Availability Checker writes its heartbeat
+type AvcBackend string
+
+// Avc defines where the Availability Checker stores its heartbeat.
+// With the table backend, every instance of pgroute66 writes its own row, identified by InstanceID.
+type Avc struct {
+	Backend    AvcBackend
+	Schema     string
+	Table      string
+	InstanceID string
+	// PrimaryLSN is the current WAL location of the primary (WAL backend only, 0 when unknown).
+	// A standby that replayed up to it is caught up, however long ago its last replayed commit is.
+	PrimaryLSN int64
+}
+
+// AvcWriter identifies the process writing a heartbeat, and the sequence number of the heartbeat
+type AvcWriter struct {
+	Writer string
+	Seq    int64
+}
+
+// AvcLag is the time since the last heartbeat
+type AvcLag struct {
+	// Own is the time since the last heartbeat of this instance, or negative when this instance has no row
+	Own time.Duration
+	// Freshest is the time since the last heartbeat of any instance
+	Freshest time.Duration
+	// Writer is the process and sequence number of the last heartbeat of this instance
+	Writer AvcWriter
+}
+
+// Lag returns the lag of this instance, or the lag of the freshest row when this instance has no row
+func (al AvcLag) Lag() time.Duration {
+	if al.Own < 0 {
+		return al.Freshest
+	}
+
+	return al.Own
+}
+
+// Corrected returns the lag, minus the false lag caused by clock skew between the writer and the reader
+func (al AvcLag) Corrected(correction time.Duration) AvcLag {
+	if al.Own >= 0 {
+		al.Own -= correction
+	}
+
+	al.Freshest -= correction
+
+	return al
+}
+
+// DefaultAvc returns the default location of the Availability Checker table
+func DefaultAvc() Avc {
+	return Avc{Backend: AvcBackendTable, Schema: AvcSchema, Table: AvcTable}
+}
+
+func (avc Avc) fullTableName() string {
+	return fmt.Sprintf("%s.%s", identifierNameSQL(avc.Schema), identifierNameSQL(avc.Table))
+}
+
+// wrapError returns ErrAvcPermissionDenied or ErrAvcTableMissing (with details) for PostgreSQL errors that mean so
+func (avc Avc) wrapError(action string, err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case sqlStateInsufficientPrivilege:
+			return fmt.Errorf("%w to %s %s: %s", ErrAvcPermissionDenied, action, avc.fullTableName(), pgErr.Message)
+		case sqlStateUndefinedTable:
+			return fmt.Errorf("%w: %s", ErrAvcTableMissing, pgErr.Message)
+		}
+	}
+
+	return fmt.Errorf("failed to %s %s: %w", action, avc.fullTableName(), err)
 }
 
-func fullTableName() string {
-	return fmt.Sprintf("%s.%s", identifierNameSQL(AvcSchema), identifierNameSQL(AvcTable))
+// AvcDurationExceededError is raised when the last heartbeat is longer ago than allowed
+type AvcDurationExceededError struct {
+	max      time.Duration
+	actually time.Duration
+	freshest time.Duration
 }
 
 func (der AvcDurationExceededError) Error() string {
-	return fmt.Sprintf("should have taken %f msec, but actually took %f msec", der.max, der.actually)
+	return fmt.Sprintf("last heartbeat should be at most %s ago, but actually was %s ago (freshest heartbeat %s ago)",
+		der.max, der.actually, der.freshest)
 }
 
 func (der AvcDurationExceededError) String() string {
-	return fmt.Sprintf("exceeded %f by %f msec", der.max, der.actually)
+	return fmt.Sprintf("exceeded %s by %s", der.max, der.actually-der.max)
 }
 
-func (c *Conn) avcTableExists(ctx context.Context) (bool, error) {
-	if exists, err := c.runQueryExists(ctx, "select relname from pg_class where relname = $1 and relnamespace in "+
+func (c *Conn) avcTableExists(ctx context.Context, avc Avc) (bool, error) {
+	exists, err := c.runQueryExists(ctx, "select relname from pg_class where relname = $1 and relnamespace in "+
 		"(select oid from pg_namespace where nspname=$2)",
-		AvcTable, AvcSchema); err != nil {
-		return false, fmt.Errorf("failed to check for table %s", fullTableName())
-	} else if exists {
-		return true, nil
+		avc.Table, avc.Schema)
+	if err != nil {
+		return false, avc.wrapError("check for table", err)
 	}
-	return false, nil
+
+	return exists, nil
 }
 
-// AvcCreateTable is a query builder for the create statement of the AVC table
-func (c *Conn) AvcCreateTable(ctx context.Context) error {
-	c.logger.Infof("Creating table")
+// avcMigrated returns whether the AVC table exists, with the instance column and the unique index on it
+func (c *Conn) avcMigrated(ctx context.Context, avc Avc) (bool, error) {
+	migrated, err := c.runQueryExists(ctx, "select i.indexrelid::regclass::text from pg_index i "+
+		"join pg_attribute a on a.attrelid = i.indrelid and a.attnum = i.indkey[0] "+
+		"where i.indrelid = to_regclass($1) and i.indisunique and i.indnatts = 1 and a.attname = $2",
+		avc.fullTableName(), AvcInstanceColumn)
+	if err != nil {
+		return false, avc.wrapError("check index on", err)
+	}
 
-	if exists, err := c.avcTableExists(ctx); err != nil {
-		c.logger.Errorf("failed to check if table %s exists: %e", fullTableName(), err)
+	return migrated, nil
+}
 
+// AvcCreateTable creates the AVC table, and adds the instance columns (to identify the instance and writer of
+// heartbeat rows) and the unique index on the instance column (required to upsert heartbeat rows) when they do not
+// exist. All is done in one transaction, and can safely be rerun (e.a. after an earlier attempt failed halfway).
+func (c *Conn) AvcCreateTable(ctx context.Context, avc Avc) error {
+	if migrated, err := c.avcMigrated(ctx, avc); err != nil || migrated {
 		return err
-	} else if exists {
-		return nil
 	}
 
-	if _, err := c.runQueryExec(ctx, fmt.Sprintf("create table %s (%s timestamp)",
-		fullTableName(), identifierNameSQL(AvcColumn))); err != nil {
-		return fmt.Errorf("failed to create table %s", fullTableName())
-	}
+	c.logger.Infof("Creating or migrating table %s", avc.fullTableName())
 
-	if affected, err := c.runQueryExec(ctx, fmt.Sprintf("insert into %s values(now())", fullTableName())); err != nil {
-		return fmt.Errorf("failed to create table %s", fullTableName())
-	} else if affected != 1 {
-		return fmt.Errorf("unexpected result while inserting into table %s", fullTableName())
+	instance := identifierNameSQL(AvcInstanceColumn)
+	// named like the indexes created without a name by previous versions
+	index := identifierNameSQL(fmt.Sprintf("%s_%s_idx", avc.Table, AvcInstanceColumn))
+
+	if err := c.runQueriesInTx(ctx,
+		fmt.Sprintf("create table if not exists %s (%s text, %s timestamp, writer text, seq bigint)",
+			avc.fullTableName(), instance, identifierNameSQL(AvcColumn)),
+		fmt.Sprintf("alter table %s add column if not exists %s text, add column if not exists writer text, "+
+			"add column if not exists seq bigint", avc.fullTableName(), instance),
+		fmt.Sprintf("create unique index if not exists %s on %s (%s)", index, avc.fullTableName(), instance),
+	); err != nil {
+		return avc.wrapError("create or migrate", err)
 	}
 
 	return nil
 }
 
-func (c *Conn) avCheckerGetDuration(ctx context.Context) (float64, error) {
-	fullColName := identifierNameSQL(AvcColumn)
+// avCheckReplayLag returns the time since the last commit replayed by a standby as Freshest (0 on a primary),
+// which the WAL backend advances with every heartbeat.
+// A standby that replayed up to primaryLSN has no lag, so that it is told apart from a standby that received
+// WAL it did not replay yet.
+func (c *Conn) avCheckReplayLag(ctx context.Context, primaryLSN int64) (lag AvcLag, err error) {
+	var freshest float64
+
+	if err = c.runQueryValue(ctx, &freshest, "select case when not pg_is_in_recovery() then 0 "+
+		"when $1::bigint > 0 and pg_wal_lsn_diff(pg_last_wal_replay_lsn(), '0/0') >= $1::bigint then 0 "+
+		"else coalesce(extract('epoch' from now() - pg_last_xact_replay_timestamp()), -1) end::float8",
+		primaryLSN); err != nil {
+		return lag, err
+	} else if freshest < 0 {
+		return lag, ErrAvcNoHeartbeat
+	}
+
+	lag.Own, lag.Freshest = -1, secondsDuration(freshest)
+
+	return lag, nil
+}
+
+// avEmitMessage writes a heartbeat as a transactional message into WAL
+func (c *Conn) avEmitMessage(ctx context.Context, avc Avc, writer AvcWriter) error {
+	if _, err := c.runQueryExec(ctx, "select pg_logical_emit_message(true, $1, $2)", AvcMessagePrefix,
+		fmt.Sprintf("instance_id=%s writer=%s seq=%d", avc.InstanceID, writer.Writer, writer.Seq)); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == sqlStateInsufficientPrivilege {
+			return fmt.Errorf("%w to emit message: %s", ErrAvcPermissionDenied, pgErr.Message)
+		}
+
+		return fmt.Errorf("failed to emit heartbeat message: %w", err)
+	}
 
-	if exists, err := c.avcTableExists(ctx); err != nil {
-		c.logger.Errorf("failed to check if table %s exists: %e", fullTableName(), err)
+	return nil
+}
 
-		return 0, err
+// AvCheckLag returns the time since the last heartbeat of this instance, and of any instance.
+// With the WAL backend, only the time since the last replayed commit (as freshest) is known.
+func (c *Conn) AvCheckLag(ctx context.Context, avc Avc) (lag AvcLag, err error) {
+	if avc.Backend == AvcBackendWAL {
+		return c.avCheckReplayLag(ctx, avc.PrimaryLSN)
+	}
+
+	if exists, err := c.avcTableExists(ctx, avc); err != nil {
+		return lag, err
 	} else if !exists {
-		return -1, nil
+		return lag, fmt.Errorf("%w: %s", ErrAvcTableMissing, avc.fullTableName())
 	}
 
-	qry := fmt.Sprintf("select extract('epoch' from (now()-%s)) duration from %s", fullColName, fullTableName())
-	var mSec float64
-	var mSecOk bool
-	if result, err := c.GetRows(ctx, qry); err != nil {
-		c.logger.Errorf("failed to retrieve duration from postgres: %e", err)
-		return 0, err
-	} else if len(result) != 1 {
-		return 0, fmt.Errorf("unexpected result while checking for duration (%d != 1)", len(result))
-	} else if value, valueOk := result[0]["duration"]; !valueOk {
-		return 0, fmt.Errorf("unexpected result while checking for duration (%d != 1)", len(result))
-	} else if mSec, mSecOk = value.(float64); !mSecOk {
-		return 0, fmt.Errorf("unexpected result type checking for duration (%T != float64)", value)
+	var own, freshest float64
+
+	err = c.runQueryRows(ctx, func(rows pgx.Rows) error {
+		return rows.Scan(&own, &freshest, &lag.Writer.Writer, &lag.Writer.Seq)
+	}, fmt.Sprintf("select coalesce(extract('epoch' from now() - o.%[1]s), -1)::float8, "+
+		"coalesce(extract('epoch' from now() - f.%[1]s), -1)::float8, coalesce(o.writer, ''), coalesce(o.seq, 0) "+
+		"from (select max(%[1]s) %[1]s from %[2]s) f left join %[2]s o on o.%[3]s = $1",
+		identifierNameSQL(AvcColumn), avc.fullTableName(), identifierNameSQL(AvcInstanceColumn)), avc.InstanceID)
+	if err != nil {
+		return lag, avc.wrapError("select from", err)
+	} else if freshest < 0 {
+		return lag, fmt.Errorf("%w: no heartbeat in %s", ErrAvcTableMissing, avc.fullTableName())
 	}
-	return mSec, nil
+
+	lag.Own, lag.Freshest = secondsDuration(own), secondsDuration(freshest)
+
+	return lag, nil
 }
 
-// AvUpdateDuration can update the AVC column
-func (c *Conn) AvUpdateDuration(ctx context.Context) error {
-	var affected int64
+// secondsDuration returns a number of seconds as a duration, keeping negative values negative
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
 
+// AvUpdateDuration writes the heartbeat of this instance into the AVC table (or WAL) of a primary.
+// With create, the table is created when it does not exist.
+func (c *Conn) AvUpdateDuration(ctx context.Context, avc Avc, create bool, writer AvcWriter) error {
 	if isPrimary, err := c.IsPrimary(ctx); err != nil {
 		return err
 	} else if !isPrimary {
-		c.logger.Infof("skipping update of %s on a standby database server", fullTableName())
+		c.logger.Infof("skipping update of %s on a standby database server", avc.fullTableName())
 
 		return nil
-	} else if err = c.AvcCreateTable(ctx); err != nil {
-		return err
-	} else if affected, err = c.runQueryExec(ctx, fmt.Sprintf("update %s set %s = now()",
-		fullTableName(), identifierNameSQL(AvcColumn))); err != nil {
-		return err
+	} else if avc.Backend == AvcBackendWAL {
+		return c.avEmitMessage(ctx, avc, writer)
+	}
+
+	if create {
+		if err := c.AvcCreateTable(ctx, avc); err != nil {
+			return err
+		}
+	}
+
+	affected, err := c.runQueryExec(ctx, fmt.Sprintf("insert into %[1]s (%[2]s, %[3]s, writer, seq) "+
+		"values ($1, now(), $2, $3) on conflict (%[2]s) do update "+
+		"set %[3]s = excluded.%[3]s, writer = excluded.writer, seq = excluded.seq",
+		avc.fullTableName(), identifierNameSQL(AvcInstanceColumn), identifierNameSQL(AvcColumn)),
+		avc.InstanceID, writer.Writer, writer.Seq)
+	if err != nil {
+		return avc.wrapError("update", err)
 	} else if affected != 1 {
-		return fmt.Errorf("unexpecetedly updated %d rows instead of 1 for %s", affected, fullTableName())
+		return fmt.Errorf("unexpecetedly updated %d rows instead of 1 for %s", affected, avc.fullTableName())
 	}
 
 	return nil
 }
 
-// AvCheckDuration will query PostgreSQL to check availability
-func (c *Conn) AvCheckDuration(ctx context.Context, maxDuration float64) error {
-	var (
-		err   error
-		since float64
-	)
-
-	if since, err = c.avCheckerGetDuration(ctx); err != nil {
+// AvCheckDuration will query PostgreSQL to check that the last heartbeat of this instance
+// (or of any instance, when this instance has no row) is at most maxDuration ago.
+// A negative maxDuration only checks that there is a heartbeat.
+// Correction is the false lag caused by clock skew, which is subtracted from the lag.
+func (c *Conn) AvCheckDuration(ctx context.Context, avc Avc, maxDuration time.Duration,
+	correction time.Duration,
+) error {
+	lag, err := c.AvCheckLag(ctx, avc)
+	if err != nil {
 		return err
-	} else if since < 0 {
-		return fmt.Errorf("table %s does not exist", fullTableName())
-	} else if since > maxDuration {
+	}
+
+	lag = lag.Corrected(correction)
+	if maxDuration >= 0 && lag.Lag() > maxDuration {
 		return AvcDurationExceededError{
 			max:      maxDuration,
-			actually: since,
+			actually: lag.Lag(),
+			freshest: lag.Freshest,
 		}
 	}
 
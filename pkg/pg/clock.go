@@ -0,0 +1,33 @@
+package pg
+
+import (
+	"context"
+	"time"
+)
+
+const clockSamples = 3
+
+// ClockSkew returns how far the clock of the server is ahead of the local clock (negative when it is behind).
+// The server time is compared with the local time halfway the round trip, using the sample with the shortest round
+// trip (the first sample might include setting up the connection).
+func (c *Conn) ClockSkew(ctx context.Context) (skew time.Duration, roundTrip time.Duration, err error) {
+	roundTrip = -1
+
+	for range clockSamples {
+		var serverTime time.Time
+
+		sent := time.Now()
+		if err = c.runQueryValue(ctx, &serverTime, "select clock_timestamp()"); err != nil {
+			return 0, 0, err
+		}
+
+		received := time.Now()
+
+		if sampleTrip := received.Sub(sent); roundTrip < 0 || sampleTrip < roundTrip {
+			roundTrip = sampleTrip
+			skew = serverTime.Sub(sent.Add(sampleTrip / 2))
+		}
+	}
+
+	return skew, roundTrip, nil
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -19,6 +20,7 @@ type Conn struct {
 	endpoint   string
 	conn       *pgxpool.Pool
 	logger     *zap.SugaredLogger
+	mutex      sync.Mutex
 }
 
 // NewConn can create a Conn object
@@ -32,6 +34,18 @@ func NewConn(connParams Dsn, logger *zap.SugaredLogger) (c *Conn) {
 	return c
 }
 
+// WithDatabase returns a new Conn to the same server, but to another database
+func (c *Conn) WithDatabase(dbname string) *Conn {
+	connParams := Dsn{}
+	for key, value := range c.connParams {
+		connParams[key] = value
+	}
+
+	connParams["dbname"] = dbname
+
+	return NewConn(connParams, c.logger)
+}
+
 // DSN returns a string value of the COnnection Parameters
 func (c *Conn) DSN() (dsn string) {
 	pairs := make([]string, 0, len(c.connParams))
@@ -42,6 +56,11 @@ func (c *Conn) DSN() (dsn string) {
 	return strings.Join(pairs[:], " ")
 }
 
+// PublicParams returns the Connection Parameters without secrets, which can be shared with clients
+func (c *Conn) PublicParams() Dsn {
+	return c.connParams.Public()
+}
+
 // Host returns the host parameter from the Connection Parameters
 func (c *Conn) Host() string {
 	value, ok := c.connParams["host"]
@@ -74,6 +93,9 @@ func (c *Conn) Port() string {
 
 // Connect can be used to actually connect the connection
 func (c *Conn) Connect(ctx context.Context) (err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	if c.conn != nil {
 		return nil
 	}
@@ -129,6 +151,63 @@ func (c *Conn) runQueryExists(ctx context.Context, query string, args ...any) (e
 	return false, err
 }
 
+func (c *Conn) runQueryValue(ctx context.Context, dest any, query string, args ...any) error {
+	c.logger.Debugf("Running query `%s` on %s", query, c.endpoint)
+
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+
+	return c.conn.QueryRow(ctx, query, args...).Scan(dest)
+}
+
+// runQueriesInTx runs queries in one transaction, which is rolled back when one of them fails
+func (c *Conn) runQueriesInTx(ctx context.Context, queries ...string) error {
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+
+	tx, err := c.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// rolling back after commit does nothing
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	for _, query := range queries {
+		c.logger.Debugf("Running query `%s` on %s", query, c.endpoint)
+
+		if _, err = tx.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// runQueryRows runs a query and calls scan for every row
+func (c *Conn) runQueryRows(ctx context.Context, scan func(rows pgx.Rows) error, query string, args ...any) error {
+	c.logger.Debugf("Running query `%s` on %s", query, c.endpoint)
+
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+
+	rows, err := c.conn.Query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = scan(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
 // GetRows runs a query and returns the results
 func (c *Conn) GetRows(
 	ctx context.Context,
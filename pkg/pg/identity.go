@@ -0,0 +1,30 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// NodeIdentity identifies a PostgreSQL server, and the cluster it belongs to
+type NodeIdentity struct {
+	// SystemIdentifier is the same for all nodes of a cluster (a primary and its standbys)
+	SystemIdentifier string
+	// ServerAddr and ServerPort are the address and port the server accepted the connection on
+	// (empty and 0 for unix socket connections)
+	ServerAddr string
+	ServerPort int
+	// StartTime is the start time of the postmaster, which distinguishes servers with the same system identifier
+	StartTime time.Time
+}
+
+// Identity returns the system identifier of the cluster, and the address, port and start time of the server
+func (c *Conn) Identity(ctx context.Context) (identity NodeIdentity, err error) {
+	err = c.runQueryRows(ctx, func(rows pgx.Rows) error {
+		return rows.Scan(&identity.SystemIdentifier, &identity.ServerAddr, &identity.ServerPort, &identity.StartTime)
+	}, "select system_identifier::text, coalesce(host(inet_server_addr()), ''), coalesce(inet_server_port(), 0), "+
+		"pg_postmaster_start_time() from pg_control_system()")
+
+	return identity, err
+}
@@ -1,4 +1,50 @@
 package pg
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 // Dsn is a string map and can hold connection parameters
 type Dsn map[string]string
+
+// isSecretParam returns true for connection parameters that should never be exposed
+func isSecretParam(key string) bool {
+	switch key {
+	case "password", "sslpassword", "b64password":
+		return true
+	}
+
+	return false
+}
+
+// Public returns a copy of the connection parameters without secrets (like password)
+func (d Dsn) Public() Dsn {
+	public := Dsn{}
+
+	for key, value := range d {
+		if !isSecretParam(key) {
+			public[key] = value
+		}
+	}
+
+	return public
+}
+
+// String returns the connection parameters as a (key sorted) connect string
+func (d Dsn) String() string {
+	keys := make([]string, 0, len(d))
+	for key := range d {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, connectStringValue(d[key])))
+	}
+
+	return strings.Join(pairs, " ")
+}
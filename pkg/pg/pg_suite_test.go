@@ -0,0 +1,13 @@
+package pg
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestPg(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Pg Suite")
+}
@@ -0,0 +1,65 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrPromoteIncomplete is returned when a standby did not finish promotion within the wait time
+var ErrPromoteIncomplete = errors.New("promotion did not complete")
+
+// SetReadOnly fences (or unfences) a primary, by setting default_transaction_read_only with ALTER SYSTEM,
+// and reloading the config. This requires superuser, or (PostgreSQL 15+) ALTER SYSTEM privilege on the parameter.
+// Note that sessions can still set transaction_read_only off, so this protects against clients, not against attacks.
+func (c *Conn) SetReadOnly(ctx context.Context, readOnly bool) error {
+	query := "alter system reset default_transaction_read_only"
+	if readOnly {
+		query = "alter system set default_transaction_read_only = on"
+	}
+
+	if _, err := c.runQueryExec(ctx, query); err != nil {
+		return err
+	}
+
+	var reloaded bool
+
+	return c.runQueryValue(ctx, &reloaded, "select pg_reload_conf()")
+}
+
+// Promote promotes a standby with pg_promote(), waiting at most wait (rounded up to whole seconds, and at least
+// one second) for the promotion to complete
+func (c *Conn) Promote(ctx context.Context, wait time.Duration) error {
+	var promoted bool
+
+	seconds := max(int(math.Ceil(wait.Seconds())), 1)
+	if err := c.runQueryValue(ctx, &promoted, "select pg_promote(true, $1)", seconds); err != nil {
+		return err
+	} else if !promoted {
+		return fmt.Errorf("%w within %s", ErrPromoteIncomplete, wait)
+	}
+
+	return nil
+}
+
+// WriteTransactions returns the pids of all other backends that hold a transaction id, e.a. that write
+func (c *Conn) WriteTransactions(ctx context.Context) (pids []int64, err error) {
+	err = c.runQueryRows(ctx, func(rows pgx.Rows) error {
+		var pid int64
+
+		if scanErr := rows.Scan(&pid); scanErr != nil {
+			return scanErr
+		}
+
+		pids = append(pids, pid)
+
+		return nil
+	}, "select pid::bigint from pg_stat_activity where backend_xid is not null and pid <> pg_backend_pid() "+
+		"order by pid")
+
+	return pids, err
+}
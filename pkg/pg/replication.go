@@ -0,0 +1,91 @@
+package pg
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CurrentLSN returns the current WAL write location of a primary, as a number of bytes
+func (c *Conn) CurrentLSN(ctx context.Context) (lsn int64, err error) {
+	err = c.runQueryValue(ctx, &lsn, "select pg_wal_lsn_diff(pg_current_wal_lsn(), '0/0')::bigint")
+
+	return lsn, err
+}
+
+// ReplayLSN returns the last WAL location replayed by a standby, as a number of bytes
+func (c *Conn) ReplayLSN(ctx context.Context) (lsn int64, err error) {
+	err = c.runQueryValue(ctx, &lsn, "select coalesce(pg_wal_lsn_diff(pg_last_wal_replay_lsn(), '0/0'), 0)::bigint")
+
+	return lsn, err
+}
+
+// ReplicationClient is a downstream (standby) as listed in pg_stat_replication on its upstream
+type ReplicationClient struct {
+	ApplicationName string
+	ClientAddr      string
+	ClientHostname  string
+	State           string
+	SyncState       string
+	SentLSN         int64
+	FlushLSN        int64
+	ReplayLSN       int64
+}
+
+// WalReceiver is the state of the WAL receiver of a standby, as listed in pg_stat_wal_receiver
+type WalReceiver struct {
+	Status      string
+	SenderHost  string
+	SenderPort  string
+	SlotName    string
+	ReceivedTLI int64
+	FlushedLSN  int64
+}
+
+// ReplicationClients returns all downstreams that are connected to this node.
+// Note that client details are only visible with pg_monitor (or superuser) privileges.
+func (c *Conn) ReplicationClients(ctx context.Context) (clients []ReplicationClient, err error) {
+	err = c.runQueryRows(ctx, func(rows pgx.Rows) error {
+		var client ReplicationClient
+
+		if scanErr := rows.Scan(&client.ApplicationName, &client.ClientAddr, &client.ClientHostname, &client.State,
+			&client.SyncState, &client.SentLSN, &client.FlushLSN, &client.ReplayLSN); scanErr != nil {
+			return scanErr
+		}
+
+		clients = append(clients, client)
+
+		return nil
+	}, "select application_name, coalesce(host(client_addr), '') client_addr, "+
+		"coalesce(client_hostname, '') client_hostname, coalesce(state, '') state, "+
+		"coalesce(sync_state, '') sync_state, "+
+		"coalesce(pg_wal_lsn_diff(sent_lsn, '0/0'), 0)::bigint sent_lsn, "+
+		"coalesce(pg_wal_lsn_diff(flush_lsn, '0/0'), 0)::bigint flush_lsn, "+
+		"coalesce(pg_wal_lsn_diff(replay_lsn, '0/0'), 0)::bigint replay_lsn "+
+		"from pg_stat_replication")
+
+	return clients, err
+}
+
+// WalReceiver returns the state of the WAL receiver of this node, and false when there is no WAL receiver
+// (on a primary, or on a standby that is not streaming)
+func (c *Conn) WalReceiver(ctx context.Context) (receiver WalReceiver, exists bool, err error) {
+	err = c.runQueryRows(ctx, func(rows pgx.Rows) error {
+		exists = true
+
+		return rows.Scan(&receiver.Status, &receiver.SenderHost, &receiver.SenderPort, &receiver.SlotName,
+			&receiver.ReceivedTLI, &receiver.FlushedLSN)
+	}, "select status, coalesce(sender_host, '') sender_host, coalesce(sender_port::text, '') sender_port, "+
+		"coalesce(slot_name, '') slot_name, coalesce(received_tli, 0)::bigint received_tli, "+
+		"coalesce(pg_wal_lsn_diff(flushed_lsn, '0/0'), 0)::bigint flushed_lsn "+
+		"from pg_stat_wal_receiver")
+
+	return receiver, exists, err
+}
+
+// ReplayPaused returns whether WAL replay is paused on a standby (with pg_wal_replay_pause())
+func (c *Conn) ReplayPaused(ctx context.Context) (paused bool, err error) {
+	err = c.runQueryValue(ctx, &paused, "select pg_is_in_recovery() and pg_is_wal_replay_paused()")
+
+	return paused, err
+}
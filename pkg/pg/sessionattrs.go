@@ -0,0 +1,99 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+)
+
+// TargetSessionAttrs defines which nodes are acceptable, with the semantics of libpq's target_session_attrs
+type TargetSessionAttrs string
+
+const (
+	// TargetAny accepts any node
+	TargetAny TargetSessionAttrs = "any"
+	// TargetReadWrite accepts nodes that accept read-write transactions by default
+	TargetReadWrite TargetSessionAttrs = "read-write"
+	// TargetReadOnly accepts nodes that do not accept read-write transactions by default
+	TargetReadOnly TargetSessionAttrs = "read-only"
+	// TargetPrimary accepts nodes that are not in hot standby mode
+	TargetPrimary TargetSessionAttrs = "primary"
+	// TargetStandby accepts nodes that are in hot standby mode
+	TargetStandby TargetSessionAttrs = "standby"
+	// TargetPreferStandby accepts standby nodes, but falls back to any node when there is no standby
+	TargetPreferStandby TargetSessionAttrs = "prefer-standby"
+)
+
+// NewTargetSessionAttrs parses and validates a target_session_attrs value
+func NewTargetSessionAttrs(attrs string) (TargetSessionAttrs, error) {
+	switch tsa := TargetSessionAttrs(attrs); tsa {
+	case TargetAny, TargetReadWrite, TargetReadOnly, TargetPrimary, TargetStandby, TargetPreferStandby:
+		return tsa, nil
+	}
+
+	return "", fmt.Errorf("invalid target_session_attrs %s (should be one of %s, %s, %s, %s, %s, or %s)", attrs,
+		TargetAny, TargetReadWrite, TargetReadOnly, TargetPrimary, TargetStandby, TargetPreferStandby)
+}
+
+// Matches returns true when a node in this state is acceptable.
+// For prefer-standby this only returns true for standbys. Falling back to other nodes is up to the caller.
+func (tsa TargetSessionAttrs) Matches(state NodeState) bool {
+	switch tsa {
+	case TargetReadWrite:
+		return !state.ReadOnly()
+	case TargetReadOnly:
+		return state.ReadOnly()
+	case TargetPrimary:
+		return !state.InHotStandby
+	case TargetStandby, TargetPreferStandby:
+		return state.InHotStandby
+	default:
+		return true
+	}
+}
+
+// NodeState holds the state of a node, as libpq evaluates it for target_session_attrs
+type NodeState struct {
+	InHotStandby               bool
+	DefaultTransactionReadOnly bool
+	TransactionReadOnly        bool
+}
+
+// ReadOnly returns true when the node does not accept read-write transactions by default,
+// e.a. a standby, or a primary that is fenced with default_transaction_read_only
+func (ns NodeState) ReadOnly() bool {
+	return ns.InHotStandby || ns.DefaultTransactionReadOnly || ns.TransactionReadOnly
+}
+
+// Fenced returns true for a primary that is fenced with default_transaction_read_only
+func (ns NodeState) Fenced() bool {
+	return !ns.InHotStandby && ns.DefaultTransactionReadOnly
+}
+
+// NodeState returns the current state of the node.
+// Note that transaction_read_only is evaluated for the pgroute66 session, and could differ for other users.
+func (c *Conn) NodeState(ctx context.Context) (state NodeState, err error) {
+	var result []map[string]any
+
+	if result, err = c.GetRows(ctx, "select pg_is_in_recovery() in_hot_standby, "+
+		"current_setting('default_transaction_read_only') = 'on' default_transaction_read_only, "+
+		"current_setting('transaction_read_only') = 'on' transaction_read_only"); err != nil {
+		return state, err
+	} else if len(result) != 1 {
+		return state, fmt.Errorf("unexpected result while checking node state (%d != 1)", len(result))
+	}
+
+	for column, dest := range map[string]*bool{
+		"in_hot_standby":                &state.InHotStandby,
+		"default_transaction_read_only": &state.DefaultTransactionReadOnly,
+		"transaction_read_only":         &state.TransactionReadOnly,
+	} {
+		value, ok := result[0][column].(bool)
+		if !ok {
+			return state, fmt.Errorf("unexpected result type checking for %s (%T != bool)", column, result[0][column])
+		}
+
+		*dest = value
+	}
+
+	return state, nil
+}
@@ -0,0 +1,128 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SyncMethod is the method of synchronous replication, as defined in synchronous_standby_names
+type SyncMethod string
+
+const (
+	// SyncPriority waits for the first num standbys in the list (FIRST)
+	SyncPriority SyncMethod = "first"
+	// SyncQuorum waits for any num standbys in the list (ANY)
+	SyncQuorum SyncMethod = "any"
+
+	syncStateSync    = "sync"
+	syncStateQuorum  = "quorum"
+	syncStandbyMatch = "*"
+)
+
+// SyncStandbyNames is the parsed value of synchronous_standby_names
+type SyncStandbyNames struct {
+	Method SyncMethod
+	// Num is the number of synchronous standbys that commits wait for, or 0 without synchronous replication
+	Num   int
+	Names []string
+}
+
+// parseSyncStandbyName removes the quotes from a quoted standby name
+func parseSyncStandbyName(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) >= 2 && strings.HasPrefix(name, `"`) && strings.HasSuffix(name, `"`) {
+		return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+	}
+
+	return name
+}
+
+// ParseSyncStandbyNames parses synchronous_standby_names, which can be one of
+//   - [FIRST] num (standby_name [, ...])
+//   - ANY num (standby_name [, ...])
+//   - standby_name [, ...] (which is the same as FIRST 1 (standby_name [, ...]))
+func ParseSyncStandbyNames(setting string) (ssn SyncStandbyNames, err error) {
+	ssn.Method = SyncPriority
+
+	rest := strings.TrimSpace(setting)
+	if rest == "" {
+		return ssn, nil
+	}
+
+	withKeyword := false
+	if keyword, after, found := strings.Cut(rest, " "); found {
+		switch strings.ToUpper(keyword) {
+		case "ANY":
+			ssn.Method = SyncQuorum
+			withKeyword = true
+		case "FIRST":
+			withKeyword = true
+		}
+
+		if withKeyword {
+			rest = strings.TrimSpace(after)
+		}
+	}
+
+	open := strings.Index(rest, "(")
+	switch {
+	case open >= 0 && strings.HasSuffix(rest, ")"):
+		if ssn.Num, err = strconv.Atoi(strings.TrimSpace(rest[:open])); err != nil {
+			return ssn, fmt.Errorf("invalid number of synchronous standbys in %s: %w", setting, err)
+		}
+
+		rest = rest[open+1 : len(rest)-1]
+	case withKeyword || open >= 0:
+		return ssn, fmt.Errorf("invalid synchronous_standby_names %s", setting)
+	default:
+		ssn.Num = 1
+	}
+
+	for _, name := range strings.Split(rest, ",") {
+		if name = parseSyncStandbyName(name); name != "" {
+			ssn.Names = append(ssn.Names, name)
+		}
+	}
+
+	return ssn, nil
+}
+
+// matches returns whether a standby (by application_name) is listed. Like in PostgreSQL, names are case-insensitive.
+func (ssn SyncStandbyNames) matches(applicationName string) bool {
+	for _, name := range ssn.Names {
+		if name == syncStandbyMatch || strings.EqualFold(name, applicationName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Synchronous returns the listed clients that commits wait for,
+// which have sync_state sync for priority (FIRST), or quorum for quorum based (ANY) synchronous replication
+func (ssn SyncStandbyNames) Synchronous(clients []ReplicationClient) (synchronous []ReplicationClient) {
+	state := syncStateSync
+	if ssn.Method == SyncQuorum {
+		state = syncStateQuorum
+	}
+
+	for _, client := range clients {
+		if client.SyncState == state && ssn.matches(client.ApplicationName) {
+			synchronous = append(synchronous, client)
+		}
+	}
+
+	return synchronous
+}
+
+// SyncStandbyNames returns the parsed synchronous_standby_names of this node
+func (c *Conn) SyncStandbyNames(ctx context.Context) (SyncStandbyNames, error) {
+	var setting string
+	if err := c.runQueryValue(ctx, &setting, "select current_setting('synchronous_standby_names')"); err != nil {
+		return SyncStandbyNames{}, err
+	}
+
+	return ParseSyncStandbyNames(setting)
+}
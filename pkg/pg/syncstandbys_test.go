@@ -0,0 +1,60 @@
+package pg
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Syncstandbys", func() {
+	Context("parsing synchronous_standby_names", func() {
+		It("should parse all forms", func() {
+			for setting, expected := range map[string]SyncStandbyNames{
+				"":                              {Method: SyncPriority},
+				"host2, host3":                  {Method: SyncPriority, Num: 1, Names: []string{"host2", "host3"}},
+				"2 (host2, host3, host4)":       {Method: SyncPriority, Num: 2, Names: []string{"host2", "host3", "host4"}},
+				"FIRST 1 (host2, \"Ho\"\"st\")": {Method: SyncPriority, Num: 1, Names: []string{"host2", "Ho\"st"}},
+				"any 2 (*)":                     {Method: SyncQuorum, Num: 2, Names: []string{"*"}},
+			} {
+				Expect(ParseSyncStandbyNames(setting)).To(Equal(expected), setting)
+			}
+		})
+		It("should reject invalid settings", func() {
+			for _, setting := range []string{"ANY host2", "x (host2)", "2 (host2"} {
+				_, err := ParseSyncStandbyNames(setting)
+				Expect(err).To(HaveOccurred(), setting)
+			}
+		})
+		It("should match standby names case insensitive, and * for any standby", func() {
+			Expect(SyncStandbyNames{Names: []string{"Host2"}}.matches("host2")).To(BeTrue())
+			Expect(SyncStandbyNames{Names: []string{"host2"}}.matches("host3")).To(BeFalse())
+			Expect(SyncStandbyNames{Names: []string{"*"}}.matches("host3")).To(BeTrue())
+		})
+	})
+	Context("finding synchronous standbys", func() {
+		clients := []ReplicationClient{
+			{ApplicationName: "host2", SyncState: "sync"},
+			{ApplicationName: "host3", SyncState: "potential"},
+			{ApplicationName: "host4", SyncState: "quorum"},
+			{ApplicationName: "host5", SyncState: "async"},
+		}
+		names := func(clients []ReplicationClient) (names []string) {
+			for _, client := range clients {
+				names = append(names, client.ApplicationName)
+			}
+
+			return names
+		}
+		It("should only return sync standbys for FIRST", func() {
+			ssn := SyncStandbyNames{Method: SyncPriority, Num: 1, Names: []string{"*"}}
+			Expect(names(ssn.Synchronous(clients))).To(Equal([]string{"host2"}))
+		})
+		It("should only return quorum standbys for ANY", func() {
+			ssn := SyncStandbyNames{Method: SyncQuorum, Num: 1, Names: []string{"*"}}
+			Expect(names(ssn.Synchronous(clients))).To(Equal([]string{"host4"}))
+		})
+		It("should only return listed standbys", func() {
+			ssn := SyncStandbyNames{Method: SyncPriority, Num: 1, Names: []string{"host3"}}
+			Expect(ssn.Synchronous(clients)).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,88 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TimelineSwitch is an entry in a timeline history file: timeline Timeline ended at LSN
+type TimelineSwitch struct {
+	Timeline int64
+	LSN      int64
+}
+
+// ParseLSN returns a WAL location (like 0/3000060) as a number of bytes
+func ParseLSN(lsn string) (int64, error) {
+	high, low, found := strings.Cut(lsn, "/")
+	if !found {
+		return 0, fmt.Errorf("invalid lsn %s", lsn)
+	}
+
+	hi, err := strconv.ParseUint(high, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lsn %s: %w", lsn, err)
+	}
+
+	lo, err := strconv.ParseUint(low, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lsn %s: %w", lsn, err)
+	}
+
+	return int64(hi<<32 | lo), nil
+}
+
+// ParseTimelineHistory parses the contents of a timeline history file.
+// Every line holds the parent timeline, the LSN where it was switched from, and a reason, separated by tabs.
+func ParseTimelineHistory(history string) (switches []TimelineSwitch, err error) {
+	for _, line := range strings.Split(history, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		timeline, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeline in history line %q: %w", line, err)
+		}
+
+		lsn, err := ParseLSN(fields[1])
+		if err != nil {
+			return nil, err
+		}
+
+		switches = append(switches, TimelineSwitch{Timeline: timeline, LSN: lsn})
+	}
+
+	return switches, nil
+}
+
+// Timeline returns the timeline of a node. For a primary, this is the timeline of the current WAL file,
+// as the timeline of the last checkpoint lags behind until the first checkpoint after promotion.
+// For a standby, this is the timeline of the last restartpoint,
+// or the timeline received by the WAL receiver when that is newer.
+func (c *Conn) Timeline(ctx context.Context) (timeline int64, err error) {
+	err = c.runQueryValue(ctx, &timeline, "select case when pg_is_in_recovery() then greatest(c.timeline_id, "+
+		"coalesce((select received_tli from pg_stat_wal_receiver), 0))::bigint "+
+		"else ('x' || substr(pg_walfile_name(pg_current_wal_lsn()), 1, 8))::bit(32)::bigint end "+
+		"from pg_control_checkpoint() c")
+
+	return timeline, err
+}
+
+// TimelineHistory returns the history of a timeline, as read from its history file in pg_wal.
+// Timeline 1 has no history. Reading the file requires superuser or pg_read_server_files privileges.
+func (c *Conn) TimelineHistory(ctx context.Context, timeline int64) ([]TimelineSwitch, error) {
+	if timeline <= 1 {
+		return nil, nil
+	}
+
+	var history string
+	if err := c.runQueryValue(ctx, &history, "select pg_read_file($1)",
+		fmt.Sprintf("pg_wal/%08X.history", timeline)); err != nil {
+		return nil, err
+	}
+
+	return ParseTimelineHistory(history)
+}